@@ -0,0 +1,587 @@
+package API
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// FIT base types
+const (
+	fitEnum    byte = 0x00
+	fitSint8   byte = 0x01
+	fitUint8   byte = 0x02
+	fitSint16  byte = 0x83
+	fitUint16  byte = 0x84
+	fitSint32  byte = 0x85
+	fitUint32  byte = 0x86
+	fitString  byte = 0x07
+	fitFloat32 byte = 0x88
+	fitFloat64 byte = 0x89
+	fitUint8z  byte = 0x0A
+	fitUint16z byte = 0x8B
+	fitUint32z byte = 0x8C
+)
+
+// FIT global message numbers
+const (
+	fitMesgFileID   uint16 = 0
+	fitMesgSession  uint16 = 18
+	fitMesgLap      uint16 = 19
+	fitMesgRecord   uint16 = 20
+	fitMesgEvent    uint16 = 21
+	fitMesgActivity uint16 = 34
+)
+
+// FIT profile values
+const (
+	fitFileActivity          = 4
+	fitManufacturerDevelop   = 255
+	fitEventTimer            = 0
+	fitEventSession          = 8
+	fitEventLap              = 9
+	fitEventActivity         = 26
+	fitEventTypeStart        = 0
+	fitEventTypeStop         = 1
+	fitEventTypeStopAll      = 4
+	fitSportGeneric          = 0
+	fitSportRunning          = 1
+	fitSportCycling          = 2
+	fitActivityTypeManual    = 0
+	fitProtocolVersion       = 0x20
+	fitProfileVersion        = 2132
+	fitHeaderSize            = 14
+	fitEpochOffset           = 631065600 // 1989-12-31T00:00:00Z as Unix time
+	fitSemicirclesPerDegrees = (1 << 31) / 180.0
+)
+
+var fitCrcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// fitCrc updates a FIT CRC-16 with data
+func fitCrc(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[b&0xF]
+
+		tmp = fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[(b>>4)&0xF]
+	}
+	return crc
+}
+
+// fitSize returns the size in bytes of a base type
+func fitSize(baseType byte) int {
+	switch baseType {
+	case fitSint16, fitUint16, fitUint16z:
+		return 2
+	case fitSint32, fitUint32, fitUint32z:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// fitInvalid returns the value meaning "no value" for a base type
+func fitInvalid(baseType byte) uint64 {
+	switch baseType {
+	case fitSint8:
+		return 0x7F
+	case fitSint16:
+		return 0x7FFF
+	case fitSint32:
+		return 0x7FFFFFFF
+	case fitUint16:
+		return 0xFFFF
+	case fitUint32:
+		return 0xFFFFFFFF
+	case fitUint8z, fitUint16z, fitUint32z:
+		return 0
+	default:
+		return 0xFF
+	}
+}
+
+type fitFieldDefinition struct {
+	num      byte
+	baseType byte
+}
+
+// fitMessage is the layout of a message, data messages list their values in the same order
+type fitMessage struct {
+	local  byte
+	global uint16
+	fields []fitFieldDefinition
+}
+
+var (
+	fitFileIDMessage = fitMessage{0, fitMesgFileID, []fitFieldDefinition{
+		{0, fitEnum},    // type
+		{1, fitUint16},  // manufacturer
+		{2, fitUint16},  // product
+		{3, fitUint32z}, // serial_number
+		{4, fitUint32},  // time_created
+	}}
+	fitEventMessage = fitMessage{1, fitMesgEvent, []fitFieldDefinition{
+		{253, fitUint32}, // timestamp
+		{0, fitEnum},     // event
+		{1, fitEnum},     // event_type
+	}}
+	fitRecordMessage = fitMessage{2, fitMesgRecord, []fitFieldDefinition{
+		{253, fitUint32}, // timestamp
+		{0, fitSint32},   // position_lat, semicircles
+		{1, fitSint32},   // position_long, semicircles
+		{2, fitUint16},   // altitude, 5 * (m + 500)
+		{3, fitUint8},    // heart_rate, bpm
+		{4, fitUint8},    // cadence, strides or revolutions per minute
+		{5, fitUint32},   // distance, cm
+		{6, fitUint16},   // speed, mm/s
+		{7, fitUint16},   // power, watts
+		{13, fitSint8},   // temperature, °C
+	}}
+	fitLapMessage = fitMessage{3, fitMesgLap, []fitFieldDefinition{
+		{253, fitUint32}, // timestamp
+		{254, fitUint16}, // message_index
+		{0, fitEnum},     // event
+		{1, fitEnum},     // event_type
+		{2, fitUint32},   // start_time
+		{7, fitUint32},   // total_elapsed_time, ms
+		{8, fitUint32},   // total_timer_time, ms
+		{9, fitUint32},   // total_distance, cm
+		{11, fitUint16},  // total_calories, kcal
+		{13, fitUint16},  // avg_speed, mm/s
+		{14, fitUint16},  // max_speed, mm/s
+		{15, fitUint8},   // avg_heart_rate, bpm
+		{16, fitUint8},   // max_heart_rate, bpm
+		{17, fitUint8},   // avg_cadence, strides per minute
+		{18, fitUint8},   // max_cadence, strides per minute
+		{25, fitEnum},    // sport
+	}}
+	fitSessionMessage = fitMessage{4, fitMesgSession, []fitFieldDefinition{
+		{253, fitUint32}, // timestamp
+		{254, fitUint16}, // message_index
+		{0, fitEnum},     // event
+		{1, fitEnum},     // event_type
+		{2, fitUint32},   // start_time
+		{5, fitEnum},     // sport
+		{7, fitUint32},   // total_elapsed_time, ms
+		{8, fitUint32},   // total_timer_time, ms
+		{9, fitUint32},   // total_distance, cm
+		{11, fitUint16},  // total_calories, kcal
+		{14, fitUint16},  // avg_speed, mm/s
+		{15, fitUint16},  // max_speed, mm/s
+		{16, fitUint8},   // avg_heart_rate, bpm
+		{17, fitUint8},   // max_heart_rate, bpm
+		{18, fitUint8},   // avg_cadence, strides per minute
+		{19, fitUint8},   // max_cadence, strides per minute
+		{25, fitUint16},  // first_lap_index
+		{26, fitUint16},  // num_laps
+	}}
+	fitActivityMessage = fitMessage{5, fitMesgActivity, []fitFieldDefinition{
+		{253, fitUint32}, // timestamp
+		{0, fitUint32},   // total_timer_time, ms
+		{1, fitUint16},   // num_sessions
+		{2, fitEnum},     // type
+		{3, fitEnum},     // event
+		{4, fitEnum},     // event_type
+		{5, fitUint32},   // local_timestamp
+	}}
+)
+
+// fitEncoder writes FIT messages to w, defining each local message type before its first
+// use, and keeps the size and the CRC of what it wrote
+type fitEncoder struct {
+	w       io.Writer
+	size    int
+	crc     uint16
+	err     error
+	defined map[byte]bool
+}
+
+func newFitEncoder(w io.Writer, crc uint16) *fitEncoder {
+	return &fitEncoder{w: w, crc: crc, defined: map[byte]bool{}}
+}
+
+func (e *fitEncoder) write(message fitMessage, values ...uint64) {
+	var data bytes.Buffer
+	if !e.defined[message.local] {
+		data.WriteByte(0x40 | message.local)
+		data.WriteByte(0)                                        // reserved
+		data.WriteByte(0)                                        // little endian
+		binary.Write(&data, binary.LittleEndian, message.global) // global message number
+		data.WriteByte(byte(len(message.fields)))                // number of fields
+		for _, field := range message.fields {
+			data.Write([]byte{field.num, byte(fitSize(field.baseType)), field.baseType})
+		}
+		e.defined[message.local] = true
+	}
+
+	data.WriteByte(message.local)
+	var b [8]byte
+	for i, field := range message.fields {
+		binary.LittleEndian.PutUint64(b[:], values[i])
+		data.Write(b[:fitSize(field.baseType)])
+	}
+	e.emit(data.Bytes())
+}
+
+// emit writes data, the first error is kept and stops the next writes
+func (e *fitEncoder) emit(data []byte) {
+	if e.err != nil {
+		return
+	}
+	n, err := e.w.Write(data)
+	e.size += n
+	e.crc = fitCrc(e.crc, data[:n])
+	e.err = err
+}
+
+// fitHeader returns the file header for dataSize bytes of messages
+func fitHeader(dataSize int) []byte {
+	header := make([]byte, fitHeaderSize)
+	header[0] = fitHeaderSize
+	header[1] = fitProtocolVersion
+	binary.LittleEndian.PutUint16(header[2:], fitProfileVersion)
+	binary.LittleEndian.PutUint32(header[4:], uint32(dataSize))
+	copy(header[8:], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:], fitCrc(0, header[:12]))
+	return header
+}
+
+// fitRecord is a sample of every stream at a point in time
+type fitRecord struct {
+	time        time.Time
+	latitude    *float64
+	longitude   *float64
+	elevation   *float64
+	heartRate   *float64
+	cadence     *float64
+	distance    float64
+	speed       *float64
+	power       *float64
+	temperature *float64
+}
+
+// fitStats aggregates records over a lap or a session
+type fitStats struct {
+	start, end         time.Time
+	distance           float64
+	speedSum, maxSpeed float64
+	speedCount         int
+	hrSum, maxHr       float64
+	hrCount            int
+	cadenceSum         float64
+	maxCadence         float64
+	cadenceCount       int
+}
+
+func (s *fitStats) add(record fitRecord) {
+	if s.start.IsZero() {
+		s.start = record.time
+	}
+	s.end = record.time
+	if record.speed != nil {
+		s.speedSum += *record.speed
+		s.speedCount++
+		s.maxSpeed = math.Max(s.maxSpeed, *record.speed)
+	}
+	if record.heartRate != nil {
+		s.hrSum += *record.heartRate
+		s.hrCount++
+		s.maxHr = math.Max(s.maxHr, *record.heartRate)
+	}
+	if record.cadence != nil {
+		s.cadenceSum += *record.cadence
+		s.cadenceCount++
+		s.maxCadence = math.Max(s.maxCadence, *record.cadence)
+	}
+}
+
+// MarshalFit encodes the activity as a FIT activity file
+func MarshalFit(activity *Activity) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := EncodeFit(&buffer, activity); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeFit writes the activity as a FIT activity file to w as it is encoded. The header
+// gives the size of the messages, which are encoded once to count it and once to write them.
+func EncodeFit(w io.Writer, activity *Activity) error {
+	records := buildFitRecords(activity)
+	if len(records) == 0 {
+		return errors.Errorf("Activity [%v] has no sample to write as FIT", activity.ID)
+	}
+
+	counter := newFitEncoder(ioutil.Discard, 0)
+	writeFitMessages(counter, activity, records)
+
+	header := fitHeader(counter.size)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	encoder := newFitEncoder(w, fitCrc(0, header))
+	writeFitMessages(encoder, activity, records)
+	encoder.emit([]byte{byte(encoder.crc), byte(encoder.crc >> 8)})
+	return encoder.err
+}
+
+// writeFitMessages encodes the messages of the activity file
+func writeFitMessages(encoder *fitEncoder, activity *Activity, records []fitRecord) {
+	start := activity.StartTime
+	end := records[len(records)-1].time
+	if activityEnd := start.Add(activity.Duration); activityEnd.After(end) {
+		end = activityEnd
+	}
+	sport := fitSport(activity.Sport)
+
+	// FIT running cadences are in strides per minute
+	cadenceFactor := 1.0
+	if activity.Sport == SportRunning {
+		cadenceFactor = 0.5
+	}
+
+	encoder.write(fitFileIDMessage, fitFileActivity, fitManufacturerDevelop, 0, 0, fitTime(start))
+	encoder.write(fitEventMessage, fitTime(start), fitEventTimer, fitEventTypeStart)
+
+	// Pauses are written as timer events, stopping the timer at their start and starting it
+	// again at their end, in time order with the records
+	pauses := SortPauses(append([]Pause{}, activity.Pauses...))
+	nextEvent := 0
+	writeEvents := func(until time.Time) {
+		for ; nextEvent < 2*len(pauses); nextEvent++ {
+			pause := pauses[nextEvent/2]
+			t, eventType := pause.Start, fitEventTypeStopAll
+			if nextEvent%2 == 1 {
+				t, eventType = pause.End, fitEventTypeStart
+			}
+			if t.After(until) {
+				return
+			}
+			encoder.write(fitEventMessage, fitTime(t), fitEventTimer, uint64(eventType))
+		}
+	}
+
+	for _, record := range records {
+		writeEvents(record.time)
+		encoder.write(fitRecordMessage,
+			fitTime(record.time),
+			fitSemicircles(record.latitude),
+			fitSemicircles(record.longitude),
+			fitScaled(record.elevation, 5, 500, fitUint16),
+			fitScaled(record.heartRate, 1, 0, fitUint8),
+			fitScaled(scale(cadenceFactor, record.cadence), 1, 0, fitUint8),
+			fitScaled(&record.distance, 100, 0, fitUint32),
+			fitScaled(record.speed, 1000, 0, fitUint16),
+			fitScaled(record.power, 1, 0, fitUint16),
+			fitSigned(record.temperature, fitSint8),
+		)
+	}
+
+	writeEvents(end)
+	encoder.write(fitEventMessage, fitTime(end), fitEventTimer, fitEventTypeStopAll)
+
+	laps := activity.Laps
+	if len(laps) == 0 {
+		laps = []Lap{{StartTime: start, Duration: end.Sub(start), Distance: activity.Distance()}}
+	}
+
+	var calories *float64
+	if summary := activity.Summary("calories"); summary != nil {
+		calories = &summary.Value
+	}
+
+	session := fitStats{}
+	for i, lap := range laps {
+		stats := fitStats{}
+		lapEnd := lap.StartTime.Add(lap.Duration)
+		for _, record := range records {
+			if !record.time.Before(lap.StartTime) && (record.time.Before(lapEnd) || i == len(laps)-1) {
+				stats.add(record)
+				session.add(record)
+			}
+		}
+
+		var lapCalories *float64
+		if calories != nil && len(laps) == 1 {
+			lapCalories = calories
+		}
+		active := lap.ActiveDuration(activity.Pauses)
+		encoder.write(fitLapMessage,
+			fitTime(lapEnd),
+			uint64(i),
+			fitEventLap,
+			fitEventTypeStop,
+			fitTime(lap.StartTime),
+			uint64(lap.Duration/time.Millisecond),
+			uint64(active/time.Millisecond),
+			fitScaled(&lap.Distance, 100, 0, fitUint32),
+			fitScaled(lapCalories, 1, 0, fitUint16),
+			fitScaled(stats.avgSpeed(lap.Distance, active), 1000, 0, fitUint16),
+			fitScaled(positive(stats.maxSpeed), 1000, 0, fitUint16),
+			fitScaled(average(stats.hrSum, stats.hrCount), 1, 0, fitUint8),
+			fitScaled(positive(stats.maxHr), 1, 0, fitUint8),
+			fitScaled(scale(cadenceFactor, average(stats.cadenceSum, stats.cadenceCount)), 1, 0, fitUint8),
+			fitScaled(scale(cadenceFactor, positive(stats.maxCadence)), 1, 0, fitUint8),
+			sport,
+		)
+	}
+
+	distance := activity.Distance()
+	encoder.write(fitSessionMessage,
+		fitTime(end),
+		0,
+		fitEventSession,
+		fitEventTypeStop,
+		fitTime(start),
+		sport,
+		uint64(end.Sub(start)/time.Millisecond),
+		uint64(activity.Duration/time.Millisecond),
+		fitScaled(&distance, 100, 0, fitUint32),
+		fitScaled(calories, 1, 0, fitUint16),
+		fitScaled(session.avgSpeed(distance, activity.Duration), 1000, 0, fitUint16),
+		fitScaled(positive(session.maxSpeed), 1000, 0, fitUint16),
+		fitScaled(average(session.hrSum, session.hrCount), 1, 0, fitUint8),
+		fitScaled(positive(session.maxHr), 1, 0, fitUint8),
+		fitScaled(scale(cadenceFactor, average(session.cadenceSum, session.cadenceCount)), 1, 0, fitUint8),
+		fitScaled(scale(cadenceFactor, positive(session.maxCadence)), 1, 0, fitUint8),
+		0,
+		uint64(len(laps)),
+	)
+
+	_, offset := end.Local().Zone()
+	encoder.write(fitActivityMessage,
+		fitTime(end),
+		uint64(activity.Duration/time.Millisecond),
+		1,
+		fitActivityTypeManual,
+		fitEventActivity,
+		fitEventTypeStop,
+		fitTime(end)+uint64(offset),
+	)
+}
+
+// buildFitRecords resamples every stream at the time of each point of the main stream
+func buildFitRecords(activity *Activity) []fitRecord {
+	times := Timeline(activity, StreamLatitude, StreamSpeed, StreamHeartRate, StreamDistance)
+
+	var latitudes, longitudes []*float64
+	if activity.HasPosition() {
+		latitudes = ResampleStream(activity, StreamLatitude, times)
+		longitudes = ResampleStream(activity, StreamLongitude, times)
+	}
+	elevations := ResampleStream(activity, StreamElevation, times)
+	heartRates := ResampleStream(activity, StreamHeartRate, times)
+	cadences := ResampleStream(activity, StreamCadence, times)
+	speeds := ResampleStream(activity, StreamSpeed, times)
+	powers := ResampleStream(activity, StreamPower, times)
+	temperatures := ResampleStream(activity, StreamTemperature, times)
+	distances := CumulativeDistance(activity.Samples(StreamDistance), times)
+
+	records := make([]fitRecord, len(times))
+	for i, t := range times {
+		records[i] = fitRecord{
+			time:        t,
+			elevation:   elevations[i],
+			heartRate:   heartRates[i],
+			cadence:     cadences[i],
+			distance:    distances[i],
+			speed:       speeds[i],
+			power:       powers[i],
+			temperature: temperatures[i],
+		}
+		if latitudes != nil {
+			records[i].latitude = latitudes[i]
+			records[i].longitude = longitudes[i]
+		}
+	}
+	return records
+}
+
+func (s fitStats) avgSpeed(distance float64, duration time.Duration) *float64 {
+	if distance > 0 && duration > 0 {
+		speed := distance / duration.Seconds()
+		return &speed
+	}
+	return average(s.speedSum, s.speedCount)
+}
+
+func fitSport(sport string) uint64 {
+	switch sport {
+	case SportRunning:
+		return fitSportRunning
+	case SportBiking:
+		return fitSportCycling
+	default:
+		return fitSportGeneric
+	}
+}
+
+func fitTime(t time.Time) uint64 {
+	return uint64(t.Unix() - fitEpochOffset)
+}
+
+func fitSemicircles(degrees *float64) uint64 {
+	if degrees == nil {
+		return fitInvalid(fitSint32)
+	}
+	return uint64(int64(math.Round(*degrees * fitSemicirclesPerDegrees)))
+}
+
+// fitScaled encodes value as (value + offset) * scale, or as the invalid value when missing or out of range
+func fitScaled(value *float64, scale, offset float64, baseType byte) uint64 {
+	invalid := fitInvalid(baseType)
+	if value == nil {
+		return invalid
+	}
+	scaled := math.Round((*value + offset) * scale)
+	if scaled < 0 || scaled >= float64(invalid) {
+		return invalid
+	}
+	return uint64(scaled)
+}
+
+// fitSigned encodes a signed integer value, in two's complement
+func fitSigned(value *float64, baseType byte) uint64 {
+	invalid := fitInvalid(baseType)
+	if value == nil {
+		return invalid
+	}
+	rounded := math.Round(*value)
+	if rounded < -float64(invalid) || rounded >= float64(invalid) {
+		return invalid
+	}
+	return uint64(int64(rounded))
+}
+
+func average(sum float64, count int) *float64 {
+	if count == 0 {
+		return nil
+	}
+	value := sum / float64(count)
+	return &value
+}
+
+func positive(value float64) *float64 {
+	if value <= 0 {
+		return nil
+	}
+	return &value
+}
+
+func scale(factor float64, value *float64) *float64 {
+	if value == nil {
+		return nil
+	}
+	scaled := *value * factor
+	return &scaled
+}
@@ -0,0 +1,436 @@
+package API
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+)
+
+// fitDefinition is the layout of the data messages of a local message type
+type fitDefinition struct {
+	global    uint16
+	byteOrder binary.ByteOrder
+	fields    []fitFieldLayout
+	// devSize is the size of the developer fields, which are skipped
+	devSize int
+}
+
+type fitFieldLayout struct {
+	num      byte
+	size     int
+	baseType byte
+}
+
+// fitValues holds the numeric fields of a data message, invalid values are absent
+type fitValues map[byte]float64
+
+func (v fitValues) get(num byte) *float64 {
+	if value, ok := v[num]; ok {
+		return &value
+	}
+	return nil
+}
+
+// scaled returns the value of the field as value / scale - offset
+func (v fitValues) scaled(num byte, scale, offset float64) *float64 {
+	value := v.get(num)
+	if value == nil {
+		return nil
+	}
+	result := *value/scale - offset
+	return &result
+}
+
+func (v fitValues) time(num byte) time.Time {
+	value, ok := v[num]
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(value)+fitEpochOffset, 0).UTC()
+}
+
+// fitMessages are the decoded messages the activity model is built from
+type fitMessages struct {
+	records  []fitValues
+	events   []fitValues
+	laps     []fitValues
+	sessions []fitValues
+}
+
+// ParseFit reads a FIT activity file, each session becomes an activity
+func ParseFit(r io.Reader) ([]*Activity, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateFit(content); err != nil {
+		return nil, errors.WithMessage(err, "Invalid FIT file")
+	}
+
+	messages, err := decodeFit(content[content[0] : len(content)-2])
+	if err != nil {
+		return nil, errors.WithMessage(err, "Invalid FIT file")
+	}
+
+	sessions := messages.sessions
+	if len(sessions) == 0 {
+		// Session messages are optional, the records then form a single activity
+		sessions = []fitValues{{}}
+	}
+
+	activities := []*Activity{}
+	for _, session := range sessions {
+		if activity := fitSessionToActivity(session, messages); activity != nil {
+			activities = append(activities, activity)
+		}
+	}
+	return activities, nil
+}
+
+func decodeFit(data []byte) (*fitMessages, error) {
+	messages := &fitMessages{}
+	definitions := map[byte]*fitDefinition{}
+	var lastTimestamp uint32
+
+	for offset := 0; offset < len(data); {
+		header := data[offset]
+		offset++
+
+		var local byte
+		var compressedTimestamp *uint32
+		switch {
+		case header&0x80 != 0:
+			// Compressed timestamp header: the time is an offset from the last timestamp
+			local = (header >> 5) & 0x03
+			timeOffset := uint32(header & 0x1F)
+			timestamp := lastTimestamp&^0x1F + timeOffset
+			if timeOffset < lastTimestamp&0x1F {
+				timestamp += 0x20
+			}
+			compressedTimestamp = &timestamp
+		case header&0x40 != 0:
+			definition, size, err := decodeFitDefinition(data[offset:], header&0x20 != 0)
+			if err != nil {
+				return nil, err
+			}
+			definitions[header&0x0F] = definition
+			offset += size
+			continue
+		default:
+			local = header & 0x0F
+		}
+
+		definition, ok := definitions[local]
+		if !ok {
+			return nil, errors.Errorf("Data message of undefined local type %d at offset %d", local, offset)
+		}
+
+		values := fitValues{}
+		for _, field := range definition.fields {
+			if offset+field.size > len(data) {
+				return nil, errors.New("Truncated data message")
+			}
+			if value, ok := decodeFitField(data[offset:offset+field.size], field.baseType, definition.byteOrder); ok {
+				values[field.num] = value
+			}
+			offset += field.size
+		}
+		offset += definition.devSize
+		if offset > len(data) {
+			return nil, errors.New("Truncated data message")
+		}
+
+		if compressedTimestamp != nil {
+			values[253] = float64(*compressedTimestamp)
+		}
+		if timestamp, ok := values[253]; ok {
+			lastTimestamp = uint32(timestamp)
+		}
+
+		switch definition.global {
+		case fitMesgRecord:
+			messages.records = append(messages.records, values)
+		case fitMesgEvent:
+			messages.events = append(messages.events, values)
+		case fitMesgLap:
+			messages.laps = append(messages.laps, values)
+		case fitMesgSession:
+			messages.sessions = append(messages.sessions, values)
+		}
+	}
+	return messages, nil
+}
+
+// decodeFitDefinition reads a definition message and returns it with its size
+func decodeFitDefinition(data []byte, developer bool) (*fitDefinition, int, error) {
+	if len(data) < 5 {
+		return nil, 0, errors.New("Truncated definition message")
+	}
+
+	definition := &fitDefinition{byteOrder: binary.LittleEndian}
+	if data[1] == 1 {
+		definition.byteOrder = binary.BigEndian
+	}
+	definition.global = definition.byteOrder.Uint16(data[2:4])
+
+	count := int(data[4])
+	size := 5 + 3*count
+	if len(data) < size {
+		return nil, 0, errors.New("Truncated definition message")
+	}
+	for i := 0; i < count; i++ {
+		field := data[5+3*i : 8+3*i]
+		definition.fields = append(definition.fields, fitFieldLayout{
+			num:      field[0],
+			size:     int(field[1]),
+			baseType: field[2],
+		})
+	}
+
+	if developer {
+		if len(data) < size+1 {
+			return nil, 0, errors.New("Truncated definition message")
+		}
+		devCount := int(data[size])
+		size++
+		if len(data) < size+3*devCount {
+			return nil, 0, errors.New("Truncated definition message")
+		}
+		for i := 0; i < devCount; i++ {
+			definition.devSize += int(data[size+3*i+1])
+		}
+		size += 3 * devCount
+	}
+	return definition, size, nil
+}
+
+// decodeFitField decodes the first value of a numeric field, strings, byte arrays
+// and invalid values are skipped
+func decodeFitField(data []byte, baseType byte, order binary.ByteOrder) (float64, bool) {
+	switch baseType {
+	case fitEnum, fitUint8, fitUint8z:
+		if len(data) < 1 || uint64(data[0]) == fitInvalid(baseType) {
+			return 0, false
+		}
+		return float64(data[0]), true
+	case fitSint8:
+		if len(data) < 1 || data[0] == 0x7F {
+			return 0, false
+		}
+		return float64(int8(data[0])), true
+	case fitUint16, fitUint16z:
+		if len(data) < 2 {
+			return 0, false
+		}
+		value := order.Uint16(data)
+		return float64(value), uint64(value) != fitInvalid(baseType)
+	case fitSint16:
+		if len(data) < 2 {
+			return 0, false
+		}
+		value := order.Uint16(data)
+		return float64(int16(value)), value != 0x7FFF
+	case fitUint32, fitUint32z:
+		if len(data) < 4 {
+			return 0, false
+		}
+		value := order.Uint32(data)
+		return float64(value), uint64(value) != fitInvalid(baseType)
+	case fitSint32:
+		if len(data) < 4 {
+			return 0, false
+		}
+		value := order.Uint32(data)
+		return float64(int32(value)), value != 0x7FFFFFFF
+	case fitFloat32:
+		if len(data) < 4 {
+			return 0, false
+		}
+		value := order.Uint32(data)
+		return float64(math.Float32frombits(value)), value != 0xFFFFFFFF
+	case fitFloat64:
+		if len(data) < 8 {
+			return 0, false
+		}
+		value := order.Uint64(data)
+		return math.Float64frombits(value), value != 0xFFFFFFFFFFFFFFFF
+	default:
+		return 0, false
+	}
+}
+
+func fitSessionToActivity(session fitValues, messages *fitMessages) *Activity {
+	start := session.time(2)
+	end := session.time(253)
+	inSession := func(t time.Time) bool {
+		return (start.IsZero() || !t.Before(start)) && (end.IsZero() || !t.After(end))
+	}
+
+	builder := newStreamBuilder()
+	var previousDistance float64
+	var previousTime time.Time
+	for _, record := range messages.records {
+		t := record.time(253)
+		if t.IsZero() || !inSession(t) {
+			continue
+		}
+
+		latitude := record.scaled(0, fitSemicirclesPerDegrees, 0)
+		longitude := record.scaled(1, fitSemicirclesPerDegrees, 0)
+		if latitude != nil && longitude != nil {
+			builder.instant(StreamLatitude, t, latitude)
+			builder.instant(StreamLongitude, t, longitude)
+		}
+
+		altitude := record.scaled(78, 5, 500)
+		if altitude == nil {
+			altitude = record.scaled(2, 5, 500)
+		}
+		builder.instant(StreamElevation, t, altitude)
+		builder.instant(StreamHeartRate, t, record.get(3))
+
+		cadence := record.get(4)
+		if fractional := record.scaled(53, 128, 0); cadence != nil && fractional != nil {
+			*cadence += *fractional
+		}
+		builder.instant(StreamCadence, t, cadence)
+
+		speed := record.scaled(73, 1000, 0)
+		if speed == nil {
+			speed = record.scaled(6, 1000, 0)
+		}
+		builder.instant(StreamSpeed, t, speed)
+		builder.instant(StreamPower, t, record.get(7))
+		builder.instant(StreamTemperature, t, record.get(13))
+
+		// Distances of records are cumulative, the distance of the first record was covered
+		// since the start of the session
+		if distance := record.scaled(5, 100, 0); distance != nil {
+			from := previousTime
+			if from.IsZero() && *distance > 0 {
+				from = t
+				if !start.IsZero() && start.Before(t) {
+					from = start
+				}
+			}
+			if !from.IsZero() {
+				delta := *distance - previousDistance
+				builder.interval(StreamDistance, from, t, &delta)
+			}
+			previousDistance = *distance
+			previousTime = t
+		}
+	}
+
+	if builder.empty() {
+		return nil
+	}
+
+	activity := builder.activity()
+	if !start.IsZero() {
+		activity.StartTime = start
+	}
+	activity.Sport = fitSportName(session.get(5))
+	activity.Pauses = fitPauses(messages.events, inSession)
+
+	if timer := session.scaled(8, 1000, 0); timer != nil {
+		activity.Duration = time.Duration(*timer * float64(time.Second))
+	} else {
+		activity.Duration = activity.EndTime().Sub(activity.StartTime)
+	}
+
+	for _, lap := range messages.laps {
+		lapStart := lap.time(2)
+		if lapStart.IsZero() || !inSession(lapStart) {
+			continue
+		}
+		var duration time.Duration
+		if elapsed := lap.scaled(7, 1000, 0); elapsed != nil {
+			duration = time.Duration(*elapsed * float64(time.Second))
+		}
+		var distance float64
+		if value := lap.scaled(9, 100, 0); value != nil {
+			distance = *value
+		}
+		trigger := LapTriggerManual
+		if value := lap.get(24); value != nil && *value == 2 {
+			trigger = LapTriggerDistance
+		}
+		activity.Laps = append(activity.Laps, Lap{
+			StartTime: lapStart,
+			Duration:  duration,
+			Distance:  distance,
+			Trigger:   trigger,
+		})
+	}
+	sort.SliceStable(activity.Laps, func(i, j int) bool {
+		return activity.Laps[i].StartTime.Before(activity.Laps[j].StartTime)
+	})
+
+	distance := activity.Distance()
+	if value := session.scaled(9, 100, 0); value != nil {
+		distance = *value
+	}
+	activity.Summaries = []Summary{
+		{Metric: "distance", Kind: SummaryTotal, Value: distance},
+	}
+	if value := session.get(11); value != nil {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "calories", Kind: SummaryTotal, Value: *value})
+	}
+	if value := session.get(16); value != nil {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "heart_rate", Kind: SummaryMean, Value: *value})
+	}
+	if value := session.scaled(14, 1000, 0); value != nil {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "speed", Kind: SummaryMean, Value: *value})
+	}
+
+	activity.normalizeCadence()
+	return activity
+}
+
+// fitPauses pairs the timer stop and start events of a session
+func fitPauses(events []fitValues, inSession func(time.Time) bool) []Pause {
+	var pauses []Pause
+	var stop time.Time
+	for _, event := range events {
+		if value := event.get(0); value == nil || *value != fitEventTimer {
+			continue
+		}
+		t := event.time(253)
+		if t.IsZero() || !inSession(t) {
+			continue
+		}
+		eventType := event.get(1)
+		switch {
+		case eventType == nil:
+		case *eventType == fitEventTypeStop || *eventType == fitEventTypeStopAll:
+			if stop.IsZero() {
+				stop = t
+			}
+		case *eventType == fitEventTypeStart:
+			if !stop.IsZero() {
+				pauses = append(pauses, Pause{Start: stop, End: t})
+				stop = time.Time{}
+			}
+		}
+	}
+	return SortPauses(pauses)
+}
+
+func fitSportName(sport *float64) string {
+	if sport == nil {
+		return SportOther
+	}
+	switch uint64(*sport) {
+	case fitSportRunning:
+		return SportRunning
+	case fitSportCycling:
+		return SportBiking
+	default:
+		return SportOther
+	}
+}
@@ -0,0 +1,105 @@
+package API
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// fitTestTimestamp ends with 30 in its 5 low bits, so that compressed timestamps roll over soon
+const fitTestTimestamp = 1000000030
+
+// fitTestFile wraps data messages in a FIT header and CRC
+func fitTestFile(messages ...[]byte) []byte {
+	data := bytes.Join(messages, nil)
+	file := append(fitHeader(len(data)), data...)
+	crc := fitCrc(0, file)
+	return append(file, byte(crc), byte(crc>>8))
+}
+
+// fitTestMessage concatenates a message header and its fields, encoded in little endian
+func fitTestMessage(header byte, fields ...interface{}) []byte {
+	buffer := bytes.NewBuffer([]byte{header})
+	for _, field := range fields {
+		binary.Write(buffer, binary.LittleEndian, field)
+	}
+	return buffer.Bytes()
+}
+
+func fitTestTime(offset uint32) time.Time {
+	return time.Unix(int64(fitTestTimestamp+offset)+fitEpochOffset, 0).UTC()
+}
+
+func TestDecodeFitCompressedTimestamps(t *testing.T) {
+	content := fitTestFile(
+		// Local type 0: record with timestamp and heart rate
+		fitTestMessage(0x40, byte(0), byte(0), fitMesgRecord, byte(2), []byte{253, 4, fitUint32, 3, 1, fitUint8}),
+		// Local type 1: record with heart rate, its timestamp is given by compressed headers
+		fitTestMessage(0x41, byte(0), byte(0), fitMesgRecord, byte(1), []byte{3, 1, fitUint8}),
+		fitTestMessage(0x00, uint32(fitTestTimestamp), byte(120)),
+		// Time offset 31 after 30: one second later
+		fitTestMessage(0x80|1<<5|31, byte(121)),
+		// Time offset 2 after 31: the offset rolls over, three seconds later
+		fitTestMessage(0x80|1<<5|2, byte(122)),
+	)
+
+	activities, err := ParseFit(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("Expected 1 activity, got %d", len(activities))
+	}
+
+	expected := []Sample{
+		{Start: fitTestTime(0), End: fitTestTime(0), Value: 120},
+		{Start: fitTestTime(1), End: fitTestTime(1), Value: 121},
+		{Start: fitTestTime(4), End: fitTestTime(4), Value: 122},
+	}
+	actual := activities[0].Samples(StreamHeartRate)
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d heart rate samples, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if !actual[i].Start.Equal(expected[i].Start) || !actual[i].End.Equal(expected[i].End) || actual[i].Value != expected[i].Value {
+			t.Errorf("Sample %d: expected %+v, got %+v", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestDecodeFitFirstRecordDistance(t *testing.T) {
+	content := fitTestFile(
+		// Local type 0: record with timestamp and distance in cm
+		fitTestMessage(0x40, byte(0), byte(0), fitMesgRecord, byte(2), []byte{253, 4, fitUint32, 5, 4, fitUint32}),
+		// Local type 1: session with timestamp and start time
+		fitTestMessage(0x41, byte(0), byte(0), fitMesgSession, byte(2), []byte{253, 4, fitUint32, 2, 4, fitUint32}),
+		fitTestMessage(0x00, uint32(fitTestTimestamp), uint32(5000)),
+		fitTestMessage(0x00, uint32(fitTestTimestamp+10), uint32(8000)),
+		fitTestMessage(0x01, uint32(fitTestTimestamp+10), uint32(fitTestTimestamp-10)),
+	)
+
+	activities, err := ParseFit(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("Expected 1 activity, got %d", len(activities))
+	}
+	activity := activities[0]
+
+	if !activity.StartTime.Equal(fitTestTime(0).Add(-10 * time.Second)) {
+		t.Errorf("Expected the activity to start with the session, got %v", activity.StartTime)
+	}
+	if distance := activity.Distance(); distance != 80 {
+		t.Errorf("Expected a distance of 80m, got %v", distance)
+	}
+
+	samples := activity.Samples(StreamDistance)
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 distance samples, got %d", len(samples))
+	}
+	if first := samples[0]; !first.Start.Equal(activity.StartTime) || !first.End.Equal(fitTestTime(0)) || first.Value != 50 {
+		t.Errorf("Expected the first record distance to be covered since the start, got %+v", first)
+	}
+}
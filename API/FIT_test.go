@@ -0,0 +1,157 @@
+package API
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fitTestActivity is a 40 seconds run with a sample of every stream each 10 seconds,
+// two laps and a pause
+func fitTestActivity() *Activity {
+	activity := &Activity{
+		ID:        "fit",
+		Sport:     SportRunning,
+		StartTime: at(0),
+		Duration:  35 * time.Second,
+		Laps: []Lap{
+			{StartTime: at(0), Duration: 20 * time.Second, Distance: 60, Trigger: LapTriggerManual},
+			{StartTime: at(20), Duration: 20 * time.Second, Distance: 60, Trigger: LapTriggerManual},
+		},
+		Pauses: []Pause{{Start: at(22), End: at(27)}},
+		Summaries: []Summary{
+			{Metric: "distance", Kind: SummaryTotal, Value: 120},
+			{Metric: "calories", Kind: SummaryTotal, Value: 42},
+		},
+	}
+
+	instants := map[StreamType]func(i int) float64{
+		StreamLatitude:    func(i int) float64 { return 48.8566 + float64(i)*0.0002 },
+		StreamLongitude:   func(i int) float64 { return 2.3522 - float64(i)*0.0001 },
+		StreamElevation:   func(i int) float64 { return 35.4 + float64(i) },
+		StreamHeartRate:   func(i int) float64 { return float64(140 + i) },
+		StreamCadence:     func(i int) float64 { return float64(170 + 2*i) },
+		StreamPower:       func(i int) float64 { return float64(250 + i) },
+		StreamTemperature: func(i int) float64 { return float64(-2 + i) },
+	}
+	for _, t := range []StreamType{StreamLatitude, StreamLongitude, StreamElevation, StreamHeartRate, StreamCadence, StreamPower, StreamTemperature} {
+		stream := Stream{Type: t}
+		for i := 0; i <= 4; i++ {
+			stream.Samples = append(stream.Samples, Sample{Start: at(10 * i), End: at(10 * i), Value: instants[t](i)})
+		}
+		activity.Streams = append(activity.Streams, stream)
+	}
+
+	speed := Stream{Type: StreamSpeed}
+	distance := Stream{Type: StreamDistance}
+	for i := 0; i < 4; i++ {
+		speed.Samples = append(speed.Samples, Sample{Start: at(10 * i), End: at(10 * (i + 1)), Value: 3 + 0.125*float64(i)})
+		distance.Samples = append(distance.Samples, Sample{Start: at(10 * i), End: at(10 * (i + 1)), Value: 30})
+	}
+	activity.Streams = append(activity.Streams, speed, distance)
+	return activity
+}
+
+func TestFitRoundTrip(t *testing.T) {
+	original := fitTestActivity()
+	content, err := MarshalFit(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activities, err := ParseFit(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("Expected 1 activity, got %d", len(activities))
+	}
+	decoded := activities[0]
+
+	if decoded.Sport != original.Sport {
+		t.Errorf("Expected sport %v, got %v", original.Sport, decoded.Sport)
+	}
+	if !decoded.StartTime.Equal(original.StartTime) {
+		t.Errorf("Expected start time %v, got %v", original.StartTime, decoded.StartTime)
+	}
+	if decoded.Duration != original.Duration {
+		t.Errorf("Expected duration %v, got %v", original.Duration, decoded.Duration)
+	}
+	if decoded.Distance() != original.Distance() {
+		t.Errorf("Expected distance %v, got %v", original.Distance(), decoded.Distance())
+	}
+	if summary := decoded.Summary("calories"); summary == nil || summary.Value != 42 {
+		t.Errorf("Expected 42 calories, got %+v", summary)
+	}
+
+	if len(decoded.Pauses) != 1 || !decoded.Pauses[0].Start.Equal(at(22)) || !decoded.Pauses[0].End.Equal(at(27)) {
+		t.Errorf("Expected the pause %+v, got %+v", original.Pauses, decoded.Pauses)
+	}
+
+	if len(decoded.Laps) != len(original.Laps) {
+		t.Fatalf("Expected %d laps, got %d", len(original.Laps), len(decoded.Laps))
+	}
+	for i, lap := range original.Laps {
+		if decoded := decoded.Laps[i]; !decoded.StartTime.Equal(lap.StartTime) || decoded.Duration != lap.Duration || decoded.Distance != lap.Distance {
+			t.Errorf("Lap %d: expected %+v, got %+v", i+1, lap, decoded)
+		}
+	}
+
+	// Values are rounded to the resolution of their FIT field
+	times := Timeline(original, StreamLatitude)
+	for stream, tolerance := range map[StreamType]float64{
+		StreamLatitude:    1e-7,
+		StreamLongitude:   1e-7,
+		StreamElevation:   0.1,
+		StreamHeartRate:   0,
+		StreamCadence:     0,
+		StreamSpeed:       0.0005,
+		StreamPower:       0,
+		StreamTemperature: 0,
+	} {
+		expected := ResampleStream(original, stream, times)
+		actual := ResampleStream(decoded, stream, times)
+		for i := range times {
+			if actual[i] == nil || math.Abs(*actual[i]-*expected[i]) > tolerance {
+				t.Errorf("%v at %v: expected %v, got %v", stream, times[i].Sub(original.StartTime), *expected[i], show(actual[i]))
+			}
+		}
+	}
+
+	expected := CumulativeDistance(original.Samples(StreamDistance), times)
+	actual := CumulativeDistance(decoded.Samples(StreamDistance), times)
+	for i := range times {
+		if math.Abs(actual[i]-expected[i]) > 0.005 {
+			t.Errorf("Distance at %v: expected %v, got %v", times[i].Sub(original.StartTime), expected[i], actual[i])
+		}
+	}
+}
+
+func TestFitCrc(t *testing.T) {
+	// The FIT CRC is the CRC-16/ARC, whose check value is the CRC of "123456789"
+	if crc := fitCrc(0, []byte("123456789")); crc != 0xBB3D {
+		t.Errorf("Expected CRC 0xBB3D, got %#04X", crc)
+	}
+
+	// Computing the CRC in parts gives the same result
+	if crc := fitCrc(fitCrc(0, []byte("1234")), []byte("56789")); crc != 0xBB3D {
+		t.Errorf("Expected CRC 0xBB3D computed in parts, got %#04X", crc)
+	}
+}
+
+func TestValidateFitDetectsCorruption(t *testing.T) {
+	content, err := MarshalFit(fitTestActivity())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFit(content); err != nil {
+		t.Fatalf("Expected a valid file, got %v", err)
+	}
+
+	content[fitHeaderSize+3] ^= 0xFF
+	if err := ValidateFit(content); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected a CRC error, got %v", err)
+	}
+}
@@ -1,14 +1,16 @@
 package API
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	"io/ioutil"
-	"os"
+	"io"
+	"math"
+	"time"
 )
 
 type GPX struct {
+	XMLName        xml.Name `xml:"gpx"`
 	Creator        string   `xml:"creator,attr"`
 	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
 	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
@@ -16,6 +18,7 @@ type GPX struct {
 	Xmlns          string   `xml:"xmlns,attr"`
 	XmlnsGpxtpx    string   `xml:"xmlns:gpxtpx,attr"`
 	XmlnsGpxx      string   `xml:"xmlns:gpxx,attr"`
+	XmlnsPwr       string   `xml:"xmlns:pwr,attr"`
 	Metadata       Metadata `xml:"metadata"`
 	Track          Track    `xml:"trk"`
 }
@@ -24,9 +27,9 @@ type Metadata struct {
 }
 
 type Track struct {
-	Name         string       `xml:"name"`
-	Type         int          `xml:"type"`
-	TrackSegment TrackSegment `xml:"trkseg"`
+	Name          string         `xml:"name"`
+	Type          int            `xml:"type,omitempty"`
+	TrackSegments []TrackSegment `xml:"trkseg"`
 }
 
 type TrackSegment struct {
@@ -34,51 +37,125 @@ type TrackSegment struct {
 }
 
 type TrackPoint struct {
-	Latitude   string       `xml:"lat,attr"`
-	Longitude  string       `xml:"lon,attr"`
-	Time       string       `xml:"time"`
-	Elevation  string       `xml:"ele"`
-	Extensions []Extensions `xml:"extensions"`
-	Start      int64
+	Latitude   string      `xml:"lat,attr"`
+	Longitude  string      `xml:"lon,attr"`
+	Elevation  string      `xml:"ele,omitempty"`
+	Time       string      `xml:"time"`
+	Extensions *Extensions `xml:"extensions,omitempty"`
 }
+
+// Extensions of a trackpoint, power is not part of TrackPointExtension and is written with
+// the Garmin PowerExtension v1
 type Extensions struct {
-	TrackPointExtensions []TrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+	TrackPointExtension *TrackPointExtension `xml:"gpxtpx:TrackPointExtension,omitempty"`
+	Power               *int                 `xml:"pwr:PowerInWatts,omitempty"`
 }
 
+// TrackPointExtension holds the Garmin TrackPointExtension v2 values, in schema order
 type TrackPointExtension struct {
-	HeartRate int `xml:"gpxtpx:hr"`
+	Temperature *float64 `xml:"gpxtpx:atemp,omitempty"`
+	HeartRate   *int     `xml:"gpxtpx:hr,omitempty"`
+	Cadence     *int     `xml:"gpxtpx:cad,omitempty"`
 }
 
-func WriteGpxToFile(activityID string, gpx *GPX) string {
-	file, err := xml.MarshalIndent(&gpx, "", " ")
-	if err != nil {
-		log.Errorf("Fail to transform struct to XML for id [%v] : %v", activityID, err)
+func BuildGpx(activity *Activity) *GPX {
+	startTimeString := activity.StartTime.UTC().Format(time.RFC3339Nano)
+
+	// Running cadences are written in strides per minute
+	cadenceFactor := 1.0
+	if activity.Sport == SportRunning {
+		cadenceFactor = 0.5
+	}
+
+	segments := []TrackSegment{}
+	for _, route := range buildRoute(activity) {
+		segment := TrackSegment{}
+		for _, point := range route {
+			tp := TrackPoint{
+				Latitude:  fmt.Sprintf("%v", point.latitude),
+				Longitude: fmt.Sprintf("%v", point.longitude),
+				Time:      point.time.UTC().Format(time.RFC3339Nano),
+			}
+			if point.elevation != nil {
+				tp.Elevation = fmt.Sprintf("%v", *point.elevation)
+			}
+
+			tpx := TrackPointExtension{
+				Temperature: point.temperature,
+				HeartRate:   roundValue(point.heartRate, 1),
+				Cadence:     roundValue(point.cadence, cadenceFactor),
+			}
+			extensions := Extensions{
+				Power: roundValue(point.power, 1),
+			}
+			if tpx != (TrackPointExtension{}) {
+				extensions.TrackPointExtension = &tpx
+			}
+			if extensions != (Extensions{}) {
+				tp.Extensions = &extensions
+			}
+			segment.TrackPoints = append(segment.TrackPoints, tp)
+		}
+		segments = append(segments, segment)
 	}
 
-	os.Mkdir("activities", os.ModePerm)
-	path := fmt.Sprintf("./activities/activity_%v.gpx", activityID)
-	err = ioutil.WriteFile(path, file, 0644)
+	return &GPX{
+		Creator:        "StravaGPX",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v2 http://www.garmin.com/xmlschemas/TrackPointExtensionv2.xsd http://www.garmin.com/xmlschemas/PowerExtension/v1 http://www.garmin.com/xmlschemas/PowerExtensionv1.xsd",
+		Version:        "1.1",
+		Xmlns:          "http://www.topografix.com/GPX/1/1",
+		XmlnsGpxtpx:    "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		XmlnsGpxx:      "http://www.garmin.com/xmlschemas/GpxExtensions/v3",
+		XmlnsPwr:       "http://www.garmin.com/xmlschemas/PowerExtension/v1",
+
+		Metadata: Metadata{
+			Time: startTimeString,
+		},
 
-	if err != nil {
-		log.Errorf("Fail to write file for id [%v], %v", activityID, err)
+		Track: Track{
+			Name:          activity.Name,
+			Type:          gpxTrackType(activity.Sport),
+			TrackSegments: segments,
+		},
 	}
-	return path
 }
 
-func WriteTcxToFile(activityID string, tcx *TrainingCenterDatabase) string {
-	file, err := xml.MarshalIndent(&tcx, "", " ")
-	if err != nil {
-		log.Errorf("Fail to transform struct to XML for id [%v] : %v", activityID, err)
+// gpxTrackType returns the Strava activity type of a sport, 0 leaves the track without type
+func gpxTrackType(sport string) int {
+	switch sport {
+	case SportRunning:
+		return 9
+	case SportBiking:
+		return 1
+	default:
+		return 0
 	}
+}
 
-	file = []byte(xml.Header + string(file))
+// roundValue returns the value multiplied by factor and rounded, or nil
+func roundValue(value *float64, factor float64) *int {
+	if value == nil {
+		return nil
+	}
+	rounded := int(math.Round(*value * factor))
+	return &rounded
+}
 
-	os.Mkdir("activities", os.ModePerm)
-	path := fmt.Sprintf("./activities/activity_%v.tcx", activityID)
-	err = ioutil.WriteFile(path, file, 0644)
+func MarshalGpx(gpx *GPX) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := EncodeGpx(&buffer, gpx); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
 
-	if err != nil {
-		log.Errorf("Fail to write file for id [%v], %v", activityID, err)
+// EncodeGpx writes the document to w as it is encoded
+func EncodeGpx(w io.Writer, gpx *GPX) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
 	}
-	return path
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", " ")
+	return encoder.Encode(gpx)
 }
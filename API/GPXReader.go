@@ -0,0 +1,265 @@
+package API
+
+import (
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reading structures are separate from the writing ones: their tags have no namespace
+// prefix so that elements match whatever prefix the file uses, and they accept GPX 1.0
+
+type gpxDocument struct {
+	Version  string `xml:"version,attr"`
+	Creator  string `xml:"creator,attr"`
+	Time     string `xml:"time"`
+	Metadata struct {
+		Time string `xml:"time"`
+	} `xml:"metadata"`
+	Tracks []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Type     string       `xml:"type"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+// gpxTimedPoint is a trackpoint with its parsed time
+type gpxTimedPoint struct {
+	*gpxPoint
+	time time.Time
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Latitude   float64  `xml:"lat,attr"`
+	Longitude  float64  `xml:"lon,attr"`
+	Elevation  *float64 `xml:"ele"`
+	Time       string   `xml:"time"`
+	Speed      *float64 `xml:"speed"`
+	Extensions xmlNode  `xml:"extensions"`
+}
+
+// xmlNode is a generic element, used to read extensions whatever their schema
+type xmlNode struct {
+	XMLName xml.Name
+	Content string    `xml:",chardata"`
+	Nodes   []xmlNode `xml:",any"`
+}
+
+// Find returns the numeric value of the first descendant element having one of the
+// local names, names are compared case insensitively
+func (n xmlNode) Find(names ...string) *float64 {
+	for _, child := range n.Nodes {
+		for _, name := range names {
+			if strings.EqualFold(child.XMLName.Local, name) {
+				if value, err := strconv.ParseFloat(strings.TrimSpace(child.Content), 64); err == nil {
+					return &value
+				}
+			}
+		}
+		if value := child.Find(names...); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// ParseGpx reads a GPX 1.0 or 1.1 document, each track becomes an activity
+func ParseGpx(r io.Reader) ([]*Activity, error) {
+	var document gpxDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, errors.WithMessage(err, "Invalid GPX document")
+	}
+
+	activities := []*Activity{}
+	for _, track := range document.Tracks {
+		activity, err := gpxTrackToActivity(track)
+		if err != nil {
+			return nil, err
+		}
+		if activity != nil {
+			activities = append(activities, activity)
+		}
+	}
+	return activities, nil
+}
+
+func gpxTrackToActivity(track gpxTrack) (*Activity, error) {
+	builder := newStreamBuilder()
+	var duration time.Duration
+	var pauses []Pause
+	var segmentEnd time.Time
+
+	for _, segment := range track.Segments {
+		points, err := segment.timedPoints()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Invalid time of trackpoint in track [%v]", track.Name)
+		}
+
+		var previous *gpxPoint
+		var previousTime time.Time
+		for _, timed := range points {
+			point, t := timed.gpxPoint, timed.time
+
+			builder.instant(StreamLatitude, t, &point.Latitude)
+			builder.instant(StreamLongitude, t, &point.Longitude)
+			builder.instant(StreamElevation, t, point.Elevation)
+			builder.instant(StreamHeartRate, t, point.Extensions.Find("hr", "heartrate"))
+			builder.instant(StreamCadence, t, point.Extensions.Find("cad", "cadence"))
+			builder.instant(StreamPower, t, point.Extensions.Find("power", "watts", "PowerInWatts"))
+			builder.instant(StreamTemperature, t, point.Extensions.Find("atemp", "temp"))
+
+			speed := point.Speed
+			if speed == nil {
+				speed = point.Extensions.Find("speed")
+			}
+			builder.instant(StreamSpeed, t, speed)
+
+			if previous != nil {
+				distance := haversine(previous.Latitude, previous.Longitude, point.Latitude, point.Longitude)
+				builder.interval(StreamDistance, previousTime, t, &distance)
+				duration += t.Sub(previousTime)
+			} else if !segmentEnd.IsZero() && t.After(segmentEnd) {
+				pauses = append(pauses, Pause{Start: segmentEnd, End: t})
+			}
+			previous = point
+			previousTime = t
+		}
+		if previous != nil {
+			segmentEnd = previousTime
+		}
+	}
+
+	if builder.empty() {
+		return nil, nil
+	}
+
+	activity := builder.activity()
+	activity.Name = track.Name
+	activity.Sport = gpxSport(track.Type)
+	activity.Duration = duration
+	activity.Pauses = SortPauses(pauses)
+	activity.normalizeCadence()
+	activity.Summaries = []Summary{
+		{Metric: "distance", Kind: SummaryTotal, Value: activity.Distance()},
+	}
+	return activity, nil
+}
+
+// timedPoints returns the points of the segment having a time, ordered by time. Points
+// without time are skipped as they cannot be placed in the streams.
+func (s gpxSegment) timedPoints() ([]gpxTimedPoint, error) {
+	points := make([]gpxTimedPoint, 0, len(s.Points))
+	for i := range s.Points {
+		point := &s.Points[i]
+		if len(strings.TrimSpace(point.Time)) == 0 {
+			continue
+		}
+		t, err := parseTime(point.Time)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, gpxTimedPoint{gpxPoint: point, time: t})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].time.Before(points[j].time)
+	})
+	return points, nil
+}
+
+// gpxSport maps the track type, either a name or the Strava activity type number
+func gpxSport(trackType string) string {
+	switch strings.ToLower(strings.TrimSpace(trackType)) {
+	case "9", "run", "running", "trail_running":
+		return SportRunning
+	case "1", "ride", "biking", "cycling", "road_biking", "mountain_biking":
+		return SportBiking
+	default:
+		return SportOther
+	}
+}
+
+// streamBuilder gathers the samples read from a file into streams
+type streamBuilder struct {
+	streams map[StreamType]*Stream
+	order   []StreamType
+	start   time.Time
+}
+
+func newStreamBuilder() *streamBuilder {
+	return &streamBuilder{
+		streams: map[StreamType]*Stream{},
+	}
+}
+
+func (b *streamBuilder) instant(t StreamType, at time.Time, value *float64) {
+	b.interval(t, at, at, value)
+}
+
+func (b *streamBuilder) interval(t StreamType, start, end time.Time, value *float64) {
+	if value == nil {
+		return
+	}
+	stream, ok := b.streams[t]
+	if !ok {
+		stream = &Stream{Type: t}
+		b.streams[t] = stream
+		b.order = append(b.order, t)
+	}
+	stream.Samples = append(stream.Samples, Sample{Start: start, End: end, Value: *value})
+	if b.start.IsZero() || start.Before(b.start) {
+		b.start = start
+	}
+}
+
+func (b *streamBuilder) empty() bool {
+	return len(b.streams) == 0
+}
+
+// activity returns an activity holding the streams, identified by its start time.
+// Samples are ordered by start time, whatever the order of the file.
+func (b *streamBuilder) activity() *Activity {
+	activity := &Activity{
+		ID:        b.start.UTC().Format("20060102T150405Z"),
+		StartTime: b.start,
+	}
+	for _, t := range b.order {
+		stream := *b.streams[t]
+		sort.SliceStable(stream.Samples, func(i, j int) bool {
+			return stream.Samples[i].Start.Before(stream.Samples[j].Start)
+		})
+		activity.Streams = append(activity.Streams, stream)
+	}
+	return activity
+}
+
+// parseTime reads an XML schema dateTime, with or without time zone
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999", value)
+}
+
+// haversine returns the distance in meters between two coordinates
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371008.8
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	deltaPhi := (lat2 - lat1) * math.Pi / 180
+	deltaLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
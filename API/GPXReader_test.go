@@ -0,0 +1,126 @@
+package API
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGpx(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		// points is the number of position samples, 0 when no activity is expected
+		points   int
+		duration time.Duration
+		pauses   []Pause
+	}{
+		{
+			name: "multiple segments",
+			document: `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+				<trk><type>running</type>
+					<trkseg>
+						<trkpt lat="48.8566" lon="2.3522"><time>2026-10-01T07:30:00Z</time></trkpt>
+						<trkpt lat="48.8568" lon="2.3522"><time>2026-10-01T07:30:10Z</time></trkpt>
+					</trkseg>
+					<trkseg>
+						<trkpt lat="48.8570" lon="2.3522"><time>2026-10-01T07:30:30Z</time></trkpt>
+						<trkpt lat="48.8572" lon="2.3522"><time>2026-10-01T07:30:40Z</time></trkpt>
+					</trkseg>
+				</trk>
+			</gpx>`,
+			points:   4,
+			duration: 20 * time.Second,
+			pauses:   []Pause{{Start: at(10), End: at(30)}},
+		},
+		{
+			name: "points without time",
+			document: `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+				<trk><trkseg>
+					<trkpt lat="48.8566" lon="2.3522"><time>2026-10-01T07:30:00Z</time></trkpt>
+					<trkpt lat="48.8567" lon="2.3522"></trkpt>
+					<trkpt lat="48.8568" lon="2.3522"><time>2026-10-01T07:30:10Z</time></trkpt>
+				</trkseg></trk>
+			</gpx>`,
+			points:   2,
+			duration: 10 * time.Second,
+		},
+		{
+			name: "track without time",
+			document: `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+				<trk><trkseg>
+					<trkpt lat="48.8566" lon="2.3522"></trkpt>
+					<trkpt lat="48.8568" lon="2.3522"></trkpt>
+				</trkseg></trk>
+			</gpx>`,
+		},
+		{
+			name: "points out of order",
+			document: `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+				<trk><trkseg>
+					<trkpt lat="48.8568" lon="2.3522"><time>2026-10-01T07:30:10Z</time></trkpt>
+					<trkpt lat="48.8566" lon="2.3522"><time>2026-10-01T07:30:00Z</time></trkpt>
+					<trkpt lat="48.8570" lon="2.3522"><time>2026-10-01T07:30:20Z</time></trkpt>
+				</trkseg></trk>
+			</gpx>`,
+			points:   3,
+			duration: 20 * time.Second,
+		},
+		{
+			name: "GPX 1.0",
+			document: `<gpx xmlns="http://www.topografix.com/GPX/1/0" version="1.0" creator="test">
+				<trk><trkseg>
+					<trkpt lat="48.8566" lon="2.3522"><ele>35</ele><time>2026-10-01T07:30:00Z</time><speed>3.2</speed></trkpt>
+					<trkpt lat="48.8568" lon="2.3522"><ele>36</ele><time>2026-10-01T07:30:10Z</time><speed>3.4</speed></trkpt>
+				</trkseg></trk>
+			</gpx>`,
+			points:   2,
+			duration: 10 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activities, err := ParseGpx(strings.NewReader(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.points == 0 {
+				if len(activities) != 0 {
+					t.Fatalf("Expected no activity, got %d", len(activities))
+				}
+				return
+			}
+			if len(activities) != 1 {
+				t.Fatalf("Expected 1 activity, got %d", len(activities))
+			}
+			activity := activities[0]
+
+			if !activity.StartTime.Equal(at(0)) {
+				t.Errorf("Expected the activity to start at %v, got %v", at(0), activity.StartTime)
+			}
+			if activity.Duration != test.duration {
+				t.Errorf("Expected a duration of %v, got %v", test.duration, activity.Duration)
+			}
+
+			latitudes := activity.Samples(StreamLatitude)
+			if len(latitudes) != test.points {
+				t.Fatalf("Expected %d points, got %d", test.points, len(latitudes))
+			}
+			for i := 1; i < len(latitudes); i++ {
+				if latitudes[i].Start.Before(latitudes[i-1].Start) || latitudes[i].Value < latitudes[i-1].Value {
+					t.Errorf("Point %d: expected the points in time order, got %+v after %+v", i, latitudes[i], latitudes[i-1])
+				}
+			}
+
+			if len(activity.Pauses) != len(test.pauses) {
+				t.Fatalf("Expected the pauses %+v, got %+v", test.pauses, activity.Pauses)
+			}
+			for i, pause := range test.pauses {
+				if !activity.Pauses[i].Start.Equal(pause.Start) || !activity.Pauses[i].End.Equal(pause.End) {
+					t.Errorf("Pause %d: expected %+v, got %+v", i, pause, activity.Pauses[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package API
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// GeoJSON is a Feature holding the route of an activity. Values measured at each point
+// follow the coordinateProperties convention of Mapbox's togeojson: arrays parallel to
+// the coordinates, nested by segment for a MultiLineString.
+type GeoJSON struct {
+	Type       string            `json:"type"`
+	Geometry   GeoJSONGeometry   `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+}
+
+// GeoJSONGeometry is a LineString, or a MultiLineString with a line per segment
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONProperties struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Sport     string `json:"sport"`
+	StartTime string `json:"start_time"`
+	// Distance in meters
+	Distance float64 `json:"distance"`
+	// Duration in seconds
+	Duration             float64                `json:"duration"`
+	CoordinateProperties GeoJSONPointProperties `json:"coordinateProperties"`
+}
+
+// GeoJSONPointProperties holds for each point its time, heart rate, elevation and speed
+// (m/s), missing values are null
+type GeoJSONPointProperties struct {
+	Times      interface{} `json:"times"`
+	HeartRates interface{} `json:"heart_rates"`
+	Elevations interface{} `json:"elevations"`
+	Speeds     interface{} `json:"speeds"`
+}
+
+func BuildGeoJSON(activity *Activity) *GeoJSON {
+	var coordinates [][][]float64
+	var times [][]string
+	var heartRates, elevations, speeds [][]*float64
+	for _, route := range buildRoute(activity) {
+		line := make([][]float64, len(route))
+		lineTimes := make([]string, len(route))
+		lineHeartRates := make([]*float64, len(route))
+		lineElevations := make([]*float64, len(route))
+		lineSpeeds := make([]*float64, len(route))
+		for i, point := range route {
+			line[i] = []float64{point.longitude, point.latitude}
+			if point.elevation != nil {
+				line[i] = append(line[i], *point.elevation)
+			}
+			lineTimes[i] = point.time.UTC().Format(time.RFC3339Nano)
+			lineHeartRates[i] = point.heartRate
+			lineElevations[i] = point.elevation
+			lineSpeeds[i] = point.speed
+		}
+		coordinates = append(coordinates, line)
+		times = append(times, lineTimes)
+		heartRates = append(heartRates, lineHeartRates)
+		elevations = append(elevations, lineElevations)
+		speeds = append(speeds, lineSpeeds)
+	}
+
+	geometry := GeoJSONGeometry{Type: "MultiLineString", Coordinates: coordinates}
+	pointProperties := GeoJSONPointProperties{Times: times, HeartRates: heartRates, Elevations: elevations, Speeds: speeds}
+	if len(coordinates) == 1 {
+		geometry = GeoJSONGeometry{Type: "LineString", Coordinates: coordinates[0]}
+		pointProperties = GeoJSONPointProperties{Times: times[0], HeartRates: heartRates[0], Elevations: elevations[0], Speeds: speeds[0]}
+	} else if len(coordinates) == 0 {
+		geometry.Coordinates = [][][]float64{}
+	}
+
+	return &GeoJSON{
+		Type:     "Feature",
+		Geometry: geometry,
+		Properties: GeoJSONProperties{
+			ID:                   activity.ID,
+			Name:                 activity.Name,
+			Sport:                activity.Sport,
+			StartTime:            activity.StartTime.UTC().Format(time.RFC3339),
+			Distance:             activity.Distance(),
+			Duration:             activity.Duration.Seconds(),
+			CoordinateProperties: pointProperties,
+		},
+	}
+}
+
+// EncodeGeoJSON writes the document to w as it is encoded
+func EncodeGeoJSON(w io.Writer, geoJSON *GeoJSON) error {
+	return json.NewEncoder(w).Encode(geoJSON)
+}
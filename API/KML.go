@@ -0,0 +1,149 @@
+package API
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// KML is a KML 2.2 document holding the route of an activity as a gx:MultiTrack, with a
+// gx:Track per segment carrying the values measured at each point as extended data
+type KML struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	XmlnsGx  string      `xml:"xmlns:gx,attr"`
+	Document KmlDocument `xml:"Document"`
+}
+
+type KmlDocument struct {
+	Name      string       `xml:"name"`
+	Schema    KmlSchema    `xml:"Schema"`
+	Placemark KmlPlacemark `xml:"Placemark"`
+}
+
+type KmlSchema struct {
+	ID     string           `xml:"id,attr"`
+	Fields []KmlSchemaField `xml:"gx:SimpleArrayField"`
+}
+
+type KmlSchemaField struct {
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	DisplayName string `xml:"displayName"`
+}
+
+type KmlPlacemark struct {
+	Name       string        `xml:"name"`
+	TimeSpan   KmlTimeSpan   `xml:"TimeSpan"`
+	MultiTrack KmlMultiTrack `xml:"gx:MultiTrack"`
+}
+
+type KmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type KmlMultiTrack struct {
+	AltitudeMode string     `xml:"altitudeMode"`
+	Interpolate  int        `xml:"gx:interpolate"`
+	Tracks       []KmlTrack `xml:"gx:Track"`
+}
+
+type KmlTrack struct {
+	When         []string        `xml:"when"`
+	Coords       []string        `xml:"gx:coord"`
+	ExtendedData KmlExtendedData `xml:"ExtendedData"`
+}
+
+type KmlExtendedData struct {
+	SchemaData KmlSchemaData `xml:"SchemaData"`
+}
+
+type KmlSchemaData struct {
+	SchemaURL string         `xml:"schemaUrl,attr"`
+	Arrays    []KmlArrayData `xml:"gx:SimpleArrayData"`
+}
+
+// KmlArrayData lists a value per point of the track, empty when unknown
+type KmlArrayData struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"gx:value"`
+}
+
+func BuildKml(activity *Activity) *KML {
+	var tracks []KmlTrack
+	var begin, end time.Time
+	for _, route := range buildRoute(activity) {
+		track := KmlTrack{
+			ExtendedData: KmlExtendedData{
+				SchemaData: KmlSchemaData{SchemaURL: "#activity"},
+			},
+		}
+		heartRates := KmlArrayData{Name: "heart_rate"}
+		elevations := KmlArrayData{Name: "elevation"}
+		speeds := KmlArrayData{Name: "speed"}
+		for _, point := range route {
+			if begin.IsZero() {
+				begin = point.time
+			}
+			end = point.time
+
+			elevation := 0.0
+			if point.elevation != nil {
+				elevation = *point.elevation
+			}
+			track.When = append(track.When, point.time.UTC().Format(time.RFC3339Nano))
+			track.Coords = append(track.Coords, fmt.Sprintf("%v %v %v", point.longitude, point.latitude, elevation))
+			heartRates.Values = append(heartRates.Values, kmlValue(point.heartRate))
+			elevations.Values = append(elevations.Values, kmlValue(point.elevation))
+			speeds.Values = append(speeds.Values, kmlValue(point.speed))
+		}
+		track.ExtendedData.SchemaData.Arrays = []KmlArrayData{heartRates, elevations, speeds}
+		tracks = append(tracks, track)
+	}
+
+	return &KML{
+		Xmlns:   "http://www.opengis.net/kml/2.2",
+		XmlnsGx: "http://www.google.com/kml/ext/2.2",
+		Document: KmlDocument{
+			Name: activity.Name,
+			Schema: KmlSchema{
+				ID: "activity",
+				Fields: []KmlSchemaField{
+					{Name: "heart_rate", Type: "int", DisplayName: "Heart rate (bpm)"},
+					{Name: "elevation", Type: "float", DisplayName: "Elevation (m)"},
+					{Name: "speed", Type: "float", DisplayName: "Speed (m/s)"},
+				},
+			},
+			Placemark: KmlPlacemark{
+				Name: activity.Name,
+				TimeSpan: KmlTimeSpan{
+					Begin: begin.UTC().Format(time.RFC3339),
+					End:   end.UTC().Format(time.RFC3339),
+				},
+				MultiTrack: KmlMultiTrack{
+					AltitudeMode: "clampToGround",
+					Tracks:       tracks,
+				},
+			},
+		},
+	}
+}
+
+// EncodeKml writes the document to w as it is encoded
+func EncodeKml(w io.Writer, kml *KML) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", " ")
+	return encoder.Encode(kml)
+}
+
+func kmlValue(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", *value)
+}
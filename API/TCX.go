@@ -1,40 +1,50 @@
 package API
 
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"time"
+)
+
 type TrainingCenterDatabase struct {
 	SchemaLocation string `xml:"xsi:schemaLocation,attr"`
 	Xmlns          string `xml:"xmlns,attr"`
-	XmlnsNs5       string `xml:"xmlns:n5,attr"`
-	XmlnsNs4       string `xml:"xmlns:n4,attr"`
-	XmlnsNs3       string `xml:"xmlns:n3,attr"`
-	XmlnsNs2       string `xml:"xmlns:n2,attr"`
+	XmlnsNs5       string `xml:"xmlns:ns5,attr"`
+	XmlnsNs4       string `xml:"xmlns:ns4,attr"`
+	XmlnsNs3       string `xml:"xmlns:ns3,attr"`
+	XmlnsNs2       string `xml:"xmlns:ns2,attr"`
 	XmlnsXsi       string `xml:"xmlns:xsi,attr"`
 
 	Activities Activities `xml:"Activities"`
 	Author     Author     `xml:"Author"`
 }
 type Activities struct {
-	Activities []Activity `xml:"Activity"`
+	Activities []TcxActivity `xml:"Activity"`
 }
 
-type Activity struct {
+type TcxActivity struct {
 	Sport string `xml:"Sport,attr"`
 
-	ID  string `xml:"Id"`
-	Lap Lap    `xml:"Lap"`
+	ID    string   `xml:"Id"`
+	Laps  []TcxLap `xml:"Lap"`
+	Notes string   `xml:"Notes,omitempty"`
 }
 
-type Lap struct {
+type TcxLap struct {
 	StartTime string `xml:"StartTime,attr"`
 
 	TotalTimeSeconds    float32       `xml:"TotalTimeSeconds"`
 	DistanceMeters      float32       `xml:"DistanceMeters"`
 	MaximumSpeed        float32       `xml:"MaximumSpeed"`
 	Calories            int32         `xml:"Calories"`
-	AverageHeartRateBpm Value         `xml:"AverageHeartRateBpm"`
-	MaximumHeartRateBpm Value         `xml:"MaximumHeartRateBpm"`
+	AverageHeartRateBpm *Value        `xml:"AverageHeartRateBpm,omitempty"`
+	MaximumHeartRateBpm *Value        `xml:"MaximumHeartRateBpm,omitempty"`
 	Intensity           string        `xml:"Intensity"`
+	Cadence             *int32        `xml:"Cadence,omitempty"`
 	TriggerMethod       string        `xml:"TriggerMethod"`
-	Track               TcxTrack      `xml:"Track"`
+	Track               *TcxTrack     `xml:"Track,omitempty"`
 	Extensions          LapExtensions `xml:"Extensions"`
 }
 
@@ -48,35 +58,51 @@ type TcxTrack struct {
 
 type TcxTrackpoint struct {
 	Time           string         `xml:"Time"`
-	DistanceMeters float32        `xml:"DistanceMeters"`
+	Position       *TcxPosition   `xml:"Position,omitempty"`
+	AltitudeMeters *float32       `xml:"AltitudeMeters,omitempty"`
+	DistanceMeters *float32       `xml:"DistanceMeters,omitempty"`
 	HeartRateBpm   *Value         `xml:"HeartRateBpm,omitempty"`
+	Cadence        *int32         `xml:"Cadence,omitempty"`
 	Extensions     TrackExtension `xml:"Extensions"`
 }
 
+type TcxPosition struct {
+	LatitudeDegrees  float64 `xml:"LatitudeDegrees"`
+	LongitudeDegrees float64 `xml:"LongitudeDegrees"`
+}
+
 type TrackExtension struct {
 	TPX TPX `xml:"TPX"`
 }
 
+// TPX holds the ActivityExtension v2 values of a trackpoint, in schema order
 type TPX struct {
-	Xmlns string  `xml:"xmlns,attr"`
-	Speed float32 `xml:"Speed"`
-	//RunCadence int32   `xml:"RunCadence"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	Speed      *float32 `xml:"Speed,omitempty"`
+	RunCadence *int32   `xml:"RunCadence,omitempty"`
+	Watts      *int32   `xml:"Watts,omitempty"`
 }
 type LapExtensions struct {
 	LX LX `xml:"LX"`
 }
 
+// LX holds the ActivityExtension v2 values of a lap, in schema order
 type LX struct {
-	AvgSpeed      float32 `xml:"AvgSpeed,omitempty"`
-	AvgRunCadence int32   `xml:"AvgRunCadence,omitempty"`
-	MaxRunCadence int32   `xml:"MaxRunCadence,omitempty"`
+	Xmlns          string  `xml:"xmlns,attr"`
+	AvgSpeed       float32 `xml:"AvgSpeed,omitempty"`
+	MaxBikeCadence int32   `xml:"MaxBikeCadence,omitempty"`
+	AvgRunCadence  int32   `xml:"AvgRunCadence,omitempty"`
+	MaxRunCadence  int32   `xml:"MaxRunCadence,omitempty"`
+	AvgWatts       int32   `xml:"AvgWatts,omitempty"`
+	MaxWatts       int32   `xml:"MaxWatts,omitempty"`
 }
 
 type Author struct {
-	Type   string `xml:"xsi:type,attr"`
-	Name   string `xml:"Name"`
-	Build  Build  `xml:"Build"`
-	LangID string `xml:"LangID"`
+	Type       string `xml:"xsi:type,attr"`
+	Name       string `xml:"Name"`
+	Build      Build  `xml:"Build"`
+	LangID     string `xml:"LangID"`
+	PartNumber string `xml:"PartNumber"`
 }
 
 type Build struct {
@@ -89,3 +115,252 @@ type Version struct {
 	BuildMajor   int `xml:"BuildMajor"`
 	BuildMinor   int `xml:"BuildMinor"`
 }
+
+func BuildTcx(activity *Activity) *TrainingCenterDatabase {
+	startTime := activity.StartTime.UTC().Format(time.RFC3339)
+
+	var distance, calories float64
+	if summary := activity.Summary("distance"); summary != nil {
+		distance = summary.Value
+	}
+	if summary := activity.Summary("calories"); summary != nil {
+		calories = summary.Value
+	}
+
+	speeds := activity.Samples(StreamSpeed)
+	heartRates := activity.Samples(StreamHeartRate)
+	cadences := activity.Samples(StreamCadence)
+	powers := activity.Samples(StreamPower)
+	running := activity.Sport == SportRunning
+
+	// Every stream is resampled at the time of the speed samples
+	times := Timeline(activity, StreamSpeed, StreamDistance, StreamHeartRate, StreamLatitude)
+	var distances []float64
+	if activity.HasStream(StreamDistance) {
+		distances = CumulativeDistance(activity.Samples(StreamDistance), times)
+	}
+	speedValues := ResampleStream(activity, StreamSpeed, times)
+	heartRateValues := ResampleStream(activity, StreamHeartRate, times)
+	cadenceValues := ResampleStream(activity, StreamCadence, times)
+	powerValues := ResampleStream(activity, StreamPower, times)
+	latitudes := ResampleStream(activity, StreamLatitude, times)
+	longitudes := ResampleStream(activity, StreamLongitude, times)
+	elevations := ResampleStream(activity, StreamElevation, times)
+
+	trackpoints := make([]TcxTrackpoint, len(times))
+	for i, t := range times {
+		tp := TcxTrackpoint{
+			Time: t.UTC().Format(time.RFC3339),
+			Extensions: TrackExtension{
+				TPX: TPX{
+					Xmlns: "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+				},
+			},
+		}
+		if latitudes[i] != nil && longitudes[i] != nil {
+			tp.Position = &TcxPosition{
+				LatitudeDegrees:  *latitudes[i],
+				LongitudeDegrees: *longitudes[i],
+			}
+		}
+		if elevation := elevations[i]; elevation != nil {
+			value := float32(*elevation)
+			tp.AltitudeMeters = &value
+		}
+		// Trackpoints are written without distance when the activity has none, rather than
+		// with a distance of zero
+		if distances != nil {
+			value := float32(distances[i])
+			tp.DistanceMeters = &value
+		}
+		if speed := speedValues[i]; speed != nil {
+			value := float32(*speed)
+			tp.Extensions.TPX.Speed = &value
+		}
+		if hr := heartRateValues[i]; hr != nil {
+			tp.HeartRateBpm = &Value{
+				Value: *roundValue32(*hr, 1),
+			}
+		}
+		// Running cadences are written in strides per minute, biking ones in the standard
+		// Cadence element
+		if cadence := cadenceValues[i]; cadence != nil {
+			if running {
+				tp.Extensions.TPX.RunCadence = roundValue32(*cadence, 0.5)
+			} else {
+				tp.Cadence = roundValue32(*cadence, 1)
+			}
+		}
+		if power := powerValues[i]; power != nil {
+			tp.Extensions.TPX.Watts = roundValue32(*power, 1)
+		}
+		trackpoints[i] = tp
+	}
+
+	laps := activity.Laps
+	if len(laps) == 0 {
+		laps = []Lap{{
+			StartTime: activity.StartTime,
+			Duration:  activity.EndTime().Sub(activity.StartTime),
+			Distance:  distance,
+			Trigger:   LapTriggerManual,
+		}}
+	}
+
+	// The time and speed of laps are those of their active part, as for the activity
+	tcxLaps := make([]TcxLap, len(laps))
+	for i, lap := range laps {
+		active := lap.ActiveDuration(activity.Pauses)
+		var speedMean float64
+		if active > 0 {
+			speedMean = lap.Distance / active.Seconds()
+		}
+		lapCalories := calories
+		if len(laps) > 1 && distance > 0 {
+			lapCalories = calories * lap.Distance / distance
+		}
+
+		triggerMethod := "Manual"
+		if lap.Trigger == LapTriggerDistance {
+			triggerMethod = "Distance"
+		}
+
+		tcxLaps[i] = TcxLap{
+			StartTime:        lap.StartTime.UTC().Format(time.RFC3339),
+			TotalTimeSeconds: float32(active.Seconds()),
+			DistanceMeters:   float32(lap.Distance),
+			Calories:         int32(lapCalories),
+			Intensity:        "Active",
+			TriggerMethod:    triggerMethod,
+			Extensions: LapExtensions{
+				LX: LX{
+					Xmlns:    "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+					AvgSpeed: float32(speedMean),
+				},
+			},
+		}
+	}
+
+	for _, speed := range speeds {
+		lap := &tcxLaps[lapIndex(laps, speed.Start)]
+		if value := float32(speed.Value); value > lap.MaximumSpeed {
+			lap.MaximumSpeed = value
+		}
+	}
+
+	heartRateStats := lapAggregates(laps, heartRates)
+	cadenceStats := lapAggregates(laps, cadences)
+	powerStats := lapAggregates(laps, powers)
+	for i := range tcxLaps {
+		lap := &tcxLaps[i]
+		if stats := heartRateStats[i]; stats.count > 0 {
+			lap.AverageHeartRateBpm = &Value{Value: int32(stats.mean())}
+			lap.MaximumHeartRateBpm = &Value{Value: int32(stats.max)}
+		}
+		if stats := cadenceStats[i]; stats.count > 0 {
+			if running {
+				lap.Extensions.LX.AvgRunCadence = *roundValue32(stats.mean(), 0.5)
+				lap.Extensions.LX.MaxRunCadence = *roundValue32(stats.max, 0.5)
+			} else {
+				lap.Cadence = roundValue32(stats.mean(), 1)
+				lap.Extensions.LX.MaxBikeCadence = *roundValue32(stats.max, 1)
+			}
+		}
+		if stats := powerStats[i]; stats.count > 0 {
+			lap.Extensions.LX.AvgWatts = *roundValue32(stats.mean(), 1)
+			lap.Extensions.LX.MaxWatts = *roundValue32(stats.max, 1)
+		}
+	}
+
+	for i, tp := range trackpoints {
+		lap := &tcxLaps[lapIndex(laps, times[i])]
+		if lap.Track == nil {
+			lap.Track = &TcxTrack{}
+		}
+		lap.Track.Trackpoint = append(lap.Track.Trackpoint, tp)
+	}
+
+	tcxActivities := []TcxActivity{}
+	tcxActivity := TcxActivity{
+		Sport: activity.Sport,
+		ID:    startTime,
+		Notes: activity.Name,
+		Laps:  tcxLaps,
+	}
+	tcxActivities = append(tcxActivities, tcxActivity)
+
+	return &TrainingCenterDatabase{
+		SchemaLocation: "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2 http://www.garmin.com/xmlschemas/TrainingCenterDatabasev2.xsd",
+		Xmlns:          "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		XmlnsNs2:       "http://www.garmin.com/xmlschemas/UserProfile/v2",
+		XmlnsNs3:       "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+		XmlnsNs4:       "http://www.garmin.com/xmlschemas/ProfileExtension/v1",
+		XmlnsNs5:       "http://www.garmin.com/xmlschemas/ActivityGoals/v1",
+
+		Activities: Activities{
+			Activities: tcxActivities,
+		},
+
+		Author: Author{
+			Type: "Application_t",
+			Name: "Aurélien Allienne",
+			Build: Build{
+				Version: Version{
+					VersionMajor: 1,
+					VersionMinor: 0,
+					BuildMajor:   1,
+					BuildMinor:   0,
+				},
+			},
+			LangID:     "en",
+			PartNumber: "000-00000-00",
+		},
+	}
+}
+
+// aggregate is the mean and maximum of the samples of a lap
+type aggregate struct {
+	sum, max float64
+	count    int
+}
+
+func (a aggregate) mean() float64 {
+	return a.sum / float64(a.count)
+}
+
+// lapAggregates aggregates the samples by lap
+func lapAggregates(laps []Lap, samples []Sample) []aggregate {
+	aggregates := make([]aggregate, len(laps))
+	for _, sample := range samples {
+		a := &aggregates[lapIndex(laps, sample.Start)]
+		a.sum += sample.Value
+		a.count++
+		a.max = math.Max(a.max, sample.Value)
+	}
+	return aggregates
+}
+
+// roundValue32 returns the value multiplied by factor and rounded
+func roundValue32(value float64, factor float64) *int32 {
+	rounded := int32(math.Round(value * factor))
+	return &rounded
+}
+
+func MarshalTcx(tcx *TrainingCenterDatabase) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := EncodeTcx(&buffer, tcx); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeTcx writes the document to w as it is encoded
+func EncodeTcx(w io.Writer, tcx *TrainingCenterDatabase) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", " ")
+	return encoder.Encode(tcx)
+}
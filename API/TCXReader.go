@@ -0,0 +1,182 @@
+package API
+
+import (
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"time"
+)
+
+type tcxDocument struct {
+	Activities struct {
+		Activities []tcxActivity `xml:"Activity"`
+	} `xml:"Activities"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	ID    string   `xml:"Id"`
+	Laps  []tcxLap `xml:"Lap"`
+	Notes string   `xml:"Notes"`
+}
+
+type tcxLap struct {
+	StartTime           string     `xml:"StartTime,attr"`
+	TotalTimeSeconds    float64    `xml:"TotalTimeSeconds"`
+	DistanceMeters      float64    `xml:"DistanceMeters"`
+	Calories            float64    `xml:"Calories"`
+	AverageHeartRateBpm *tcxValue  `xml:"AverageHeartRateBpm"`
+	TriggerMethod       string     `xml:"TriggerMethod"`
+	Tracks              []tcxTrack `xml:"Track"`
+}
+
+type tcxValue struct {
+	Value float64 `xml:"Value"`
+}
+
+type tcxTrack struct {
+	Points []tcxPoint `xml:"Trackpoint"`
+}
+
+type tcxPoint struct {
+	Time     string `xml:"Time"`
+	Position *struct {
+		Latitude  float64 `xml:"LatitudeDegrees"`
+		Longitude float64 `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+	Altitude     *float64  `xml:"AltitudeMeters"`
+	Distance     *float64  `xml:"DistanceMeters"`
+	HeartRateBpm *tcxValue `xml:"HeartRateBpm"`
+	Cadence      *float64  `xml:"Cadence"`
+	Extensions   xmlNode   `xml:"Extensions"`
+}
+
+// ParseTcx reads a TCX document, each activity of the document becomes an activity
+func ParseTcx(r io.Reader) ([]*Activity, error) {
+	var document tcxDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, errors.WithMessage(err, "Invalid TCX document")
+	}
+
+	activities := []*Activity{}
+	for _, tcx := range document.Activities.Activities {
+		activity, err := tcxToActivity(tcx)
+		if err != nil {
+			return nil, err
+		}
+		if activity != nil {
+			activities = append(activities, activity)
+		}
+	}
+	return activities, nil
+}
+
+func tcxToActivity(tcx tcxActivity) (*Activity, error) {
+	builder := newStreamBuilder()
+	var laps []Lap
+	var duration time.Duration
+	var distance, calories, heartRateSum, heartRateWeight float64
+
+	// DistanceMeters of trackpoints is cumulative over the activity
+	var previousDistance float64
+	var previousTime time.Time
+
+	for _, lap := range tcx.Laps {
+		start, err := parseTime(lap.StartTime)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Invalid start time of lap in activity [%v]", tcx.ID)
+		}
+		lapDuration := time.Duration(lap.TotalTimeSeconds * float64(time.Second))
+		trigger := LapTriggerManual
+		if lap.TriggerMethod == "Distance" {
+			trigger = LapTriggerDistance
+		}
+		laps = append(laps, Lap{
+			StartTime: start,
+			Duration:  lapDuration,
+			Distance:  lap.DistanceMeters,
+			Trigger:   trigger,
+		})
+		duration += lapDuration
+		distance += lap.DistanceMeters
+		calories += lap.Calories
+		if lap.AverageHeartRateBpm != nil {
+			heartRateSum += lap.AverageHeartRateBpm.Value * lap.TotalTimeSeconds
+			heartRateWeight += lap.TotalTimeSeconds
+		}
+
+		for _, track := range lap.Tracks {
+			for _, point := range track.Points {
+				t, err := parseTime(point.Time)
+				if err != nil {
+					return nil, errors.WithMessagef(err, "Invalid time of trackpoint in activity [%v]", tcx.ID)
+				}
+
+				if point.Position != nil {
+					builder.instant(StreamLatitude, t, &point.Position.Latitude)
+					builder.instant(StreamLongitude, t, &point.Position.Longitude)
+				}
+				builder.instant(StreamElevation, t, point.Altitude)
+				if point.HeartRateBpm != nil {
+					builder.instant(StreamHeartRate, t, &point.HeartRateBpm.Value)
+				}
+				builder.instant(StreamSpeed, t, point.Extensions.Find("Speed"))
+
+				cadence := point.Cadence
+				if runCadence := point.Extensions.Find("RunCadence"); runCadence != nil {
+					cadence = runCadence
+				}
+				builder.instant(StreamCadence, t, cadence)
+				builder.instant(StreamPower, t, point.Extensions.Find("Watts"))
+
+				if point.Distance != nil {
+					if !previousTime.IsZero() {
+						delta := *point.Distance - previousDistance
+						builder.interval(StreamDistance, previousTime, t, &delta)
+					}
+					previousDistance = *point.Distance
+					previousTime = t
+				}
+			}
+		}
+	}
+
+	if builder.empty() {
+		return nil, nil
+	}
+
+	activity := builder.activity()
+	if start, err := parseTime(tcx.ID); err == nil {
+		activity.ID = start.UTC().Format("20060102T150405Z")
+		activity.StartTime = start
+	}
+	activity.Name = strings.TrimSpace(tcx.Notes)
+	activity.Sport = tcxSport(tcx.Sport)
+	activity.Duration = duration
+	activity.Laps = laps
+	activity.normalizeCadence()
+
+	activity.Summaries = []Summary{
+		{Metric: "distance", Kind: SummaryTotal, Value: distance},
+	}
+	if calories > 0 {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "calories", Kind: SummaryTotal, Value: calories})
+	}
+	if heartRateWeight > 0 {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "heart_rate", Kind: SummaryMean, Value: heartRateSum / heartRateWeight})
+	}
+	if duration > 0 {
+		activity.Summaries = append(activity.Summaries, Summary{Metric: "speed", Kind: SummaryMean, Value: distance / duration.Seconds()})
+	}
+	return activity, nil
+}
+
+func tcxSport(sport string) string {
+	switch sport {
+	case SportRunning, SportBiking:
+		return sport
+	default:
+		return SportOther
+	}
+}
@@ -0,0 +1,102 @@
+package API
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTcx(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		laps     []Lap
+		// distances are the distance samples, covered between consecutive trackpoints
+		distances []float64
+	}{
+		{
+			name: "single lap",
+			document: `<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+				<Activities><Activity Sport="Running"><Id>2026-10-01T07:30:00Z</Id>
+					<Lap StartTime="2026-10-01T07:30:00Z">
+						<TotalTimeSeconds>20</TotalTimeSeconds><DistanceMeters>60</DistanceMeters>
+						<TriggerMethod>Manual</TriggerMethod>
+						<Track>
+							<Trackpoint><Time>2026-10-01T07:30:00Z</Time><DistanceMeters>0</DistanceMeters></Trackpoint>
+							<Trackpoint><Time>2026-10-01T07:30:20Z</Time><DistanceMeters>60</DistanceMeters></Trackpoint>
+						</Track>
+					</Lap>
+				</Activity></Activities>
+			</TrainingCenterDatabase>`,
+			laps:      []Lap{{StartTime: at(0), Duration: 20 * time.Second, Distance: 60, Trigger: LapTriggerManual}},
+			distances: []float64{60},
+		},
+		{
+			name: "multiple laps",
+			document: `<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+				<Activities><Activity Sport="Running"><Id>2026-10-01T07:30:00Z</Id>
+					<Lap StartTime="2026-10-01T07:30:00Z">
+						<TotalTimeSeconds>300</TotalTimeSeconds><DistanceMeters>1000</DistanceMeters>
+						<TriggerMethod>Distance</TriggerMethod>
+						<Track>
+							<Trackpoint><Time>2026-10-01T07:30:00Z</Time><DistanceMeters>0</DistanceMeters></Trackpoint>
+							<Trackpoint><Time>2026-10-01T07:35:00Z</Time><DistanceMeters>1000</DistanceMeters></Trackpoint>
+						</Track>
+					</Lap>
+					<Lap StartTime="2026-10-01T07:35:00Z">
+						<TotalTimeSeconds>120</TotalTimeSeconds><DistanceMeters>400</DistanceMeters>
+						<TriggerMethod>Manual</TriggerMethod>
+						<Track>
+							<Trackpoint><Time>2026-10-01T07:37:00Z</Time><DistanceMeters>1400</DistanceMeters></Trackpoint>
+						</Track>
+					</Lap>
+				</Activity></Activities>
+			</TrainingCenterDatabase>`,
+			laps: []Lap{
+				{StartTime: at(0), Duration: 300 * time.Second, Distance: 1000, Trigger: LapTriggerDistance},
+				{StartTime: at(300), Duration: 120 * time.Second, Distance: 400, Trigger: LapTriggerManual},
+			},
+			// The distance of a lap continues from the last trackpoint of the previous one
+			distances: []float64{1000, 400},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activities, err := ParseTcx(strings.NewReader(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(activities) != 1 {
+				t.Fatalf("Expected 1 activity, got %d", len(activities))
+			}
+			activity := activities[0]
+
+			if activity.Sport != SportRunning {
+				t.Errorf("Expected sport %v, got %v", SportRunning, activity.Sport)
+			}
+			if !activity.StartTime.Equal(at(0)) {
+				t.Errorf("Expected the activity to start at %v, got %v", at(0), activity.StartTime)
+			}
+
+			if len(activity.Laps) != len(test.laps) {
+				t.Fatalf("Expected %d laps, got %d", len(test.laps), len(activity.Laps))
+			}
+			for i, lap := range test.laps {
+				if actual := activity.Laps[i]; !actual.StartTime.Equal(lap.StartTime) || actual.Duration != lap.Duration || actual.Distance != lap.Distance || actual.Trigger != lap.Trigger {
+					t.Errorf("Lap %d: expected %+v, got %+v", i+1, lap, actual)
+				}
+			}
+
+			distances := activity.Samples(StreamDistance)
+			if len(distances) != len(test.distances) {
+				t.Fatalf("Expected %d distance samples, got %d", len(test.distances), len(distances))
+			}
+			for i, distance := range test.distances {
+				if distances[i].Value != distance {
+					t.Errorf("Distance %d: expected %v, got %v", i, distance, distances[i].Value)
+				}
+			}
+		})
+	}
+}
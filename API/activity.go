@@ -0,0 +1,172 @@
+package API
+
+import (
+	"time"
+)
+
+// Sports, named after the TCX Sport_t values
+const (
+	SportRunning = "Running"
+	SportBiking  = "Biking"
+	SportOther   = "Other"
+)
+
+type StreamType string
+
+// Stream types and their canonical unit
+const (
+	StreamLatitude    StreamType = "latitude"    // degrees
+	StreamLongitude   StreamType = "longitude"   // degrees
+	StreamElevation   StreamType = "elevation"   // meters
+	StreamHeartRate   StreamType = "heart_rate"  // beats per minute
+	StreamSpeed       StreamType = "speed"       // meters per second
+	StreamDistance    StreamType = "distance"    // meters covered during the sample
+	StreamCadence     StreamType = "cadence"     // steps per minute, revolutions per minute when biking
+	StreamPower       StreamType = "power"       // watts
+	StreamTemperature StreamType = "temperature" // degrees Celsius
+)
+
+// Summary kinds
+const (
+	SummaryTotal = "total"
+	SummaryMean  = "mean"
+	SummaryMax   = "max"
+)
+
+// Activity is the provider-neutral representation of an activity, produced by
+// sources and consumed by format writers. All values use the canonical units
+// documented on the stream types.
+type Activity struct {
+	ID        string            `json:"id"`
+	Source    string            `json:"source"`
+	Sport     string            `json:"sport"`
+	Name      string            `json:"name"`
+	StartTime time.Time         `json:"start_time"`
+	Duration  time.Duration     `json:"duration"`
+	Streams   []Stream          `json:"streams"`
+	Laps      []Lap             `json:"laps,omitempty"`
+	Pauses    []Pause           `json:"pauses,omitempty"`
+	Summaries []Summary         `json:"summaries"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Stream is a series of samples of the same metric, ordered by start time
+type Stream struct {
+	Type    StreamType `json:"type"`
+	Samples []Sample   `json:"samples"`
+}
+
+// Sample is a value measured over the [Start, End] interval, both bounds are
+// equal for instant measures such as positions
+type Sample struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Value float64   `json:"value"`
+}
+
+// Lap is a part of the activity, either recorded by the user or split by distance.
+// Its duration is the elapsed time, pauses included.
+type Lap struct {
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+	Distance  float64       `json:"distance"`
+	Trigger   string        `json:"trigger,omitempty"`
+}
+
+// Pause is a period during which the activity was paused, ordered by start time
+type Pause struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Summary is an aggregated value over the whole activity, e.g. the total
+// distance or the mean heart rate
+type Summary struct {
+	Metric string  `json:"metric"`
+	Kind   string  `json:"kind"`
+	Value  float64 `json:"value"`
+}
+
+// Stream returns the stream of the given type, or nil if the activity does not have it
+func (a *Activity) Stream(t StreamType) *Stream {
+	for i := range a.Streams {
+		if a.Streams[i].Type == t {
+			return &a.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Samples returns the samples of the stream of the given type, or nil if the activity does not have it
+func (a *Activity) Samples(t StreamType) []Sample {
+	stream := a.Stream(t)
+	if stream == nil {
+		return nil
+	}
+	return stream.Samples
+}
+
+func (a *Activity) HasStream(t StreamType) bool {
+	return a.Stream(t) != nil
+}
+
+// Summary returns the summary of the given metric, or nil if the activity does not have it
+func (a *Activity) Summary(metric string) *Summary {
+	for i := range a.Summaries {
+		if a.Summaries[i].Metric == metric {
+			return &a.Summaries[i]
+		}
+	}
+	return nil
+}
+
+// SummaryOf returns the summary of the given metric and kind, or nil if the activity does not have it
+func (a *Activity) SummaryOf(metric, kind string) *Summary {
+	for i := range a.Summaries {
+		if a.Summaries[i].Metric == metric && a.Summaries[i].Kind == kind {
+			return &a.Summaries[i]
+		}
+	}
+	return nil
+}
+
+// Distance returns the total distance in meters, from the summary when available
+func (a *Activity) Distance() float64 {
+	if summary := a.Summary("distance"); summary != nil {
+		return summary.Value
+	}
+	var distance float64
+	for _, sample := range a.Samples(StreamDistance) {
+		distance += sample.Value
+	}
+	return distance
+}
+
+// EndTime returns the end of the last sample, or the end of the duration when the
+// activity has no sample
+func (a *Activity) EndTime() time.Time {
+	end := a.StartTime.Add(a.Duration)
+	for _, stream := range a.Streams {
+		if n := len(stream.Samples); n > 0 && stream.Samples[n-1].End.After(end) {
+			end = stream.Samples[n-1].End
+		}
+	}
+	return end
+}
+
+// HasPosition reports whether the activity carries a GPS track
+func (a *Activity) HasPosition() bool {
+	return a.HasStream(StreamLatitude) && a.HasStream(StreamLongitude)
+}
+
+// normalizeCadence converts a running cadence read from a file, where it is given in
+// strides per minute, to steps per minute
+func (a *Activity) normalizeCadence() {
+	stream := a.Stream(StreamCadence)
+	if stream == nil || a.Sport != SportRunning {
+		return
+	}
+	for i := range stream.Samples {
+		stream.Samples[i].Value *= 2
+	}
+}
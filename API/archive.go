@@ -0,0 +1,101 @@
+package API
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultArchiveDirectory is the directory raw activities are archived to when
+// RUNSYNC_ARCHIVE_DIR is not set
+const DefaultArchiveDirectory = "./archive"
+
+// ArchiveDecoder converts an activity document archived as returned by a source
+type ArchiveDecoder func(content []byte) (*Activity, error)
+
+var archiveDecoders = map[string]ArchiveDecoder{}
+
+// RegisterArchive makes the documents archived by a source readable, it is meant to be
+// called from an init function
+func RegisterArchive(source string, decoder ArchiveDecoder) {
+	if _, exists := archiveDecoders[source]; exists {
+		panic("archive already registered: " + source)
+	}
+	archiveDecoders[source] = decoder
+}
+
+// ArchiveDirectory returns the directory raw activities are archived to
+func ArchiveDirectory() string {
+	if directory := os.Getenv("RUNSYNC_ARCHIVE_DIR"); len(directory) > 0 {
+		return directory
+	}
+	return DefaultArchiveDirectory
+}
+
+// ArchivePath returns the path the document of an activity retrieved from source is archived to
+func ArchivePath(directory, source, activityID string) string {
+	return filepath.Join(directory, fmt.Sprintf("%v_%v.%v", source, activityID, FormatJson))
+}
+
+// WriteArchive saves the document of an activity as returned by source, unmodified, and
+// returns the file path
+func WriteArchive(directory, source, activityID string, content []byte) (string, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return "", errors.WithMessagef(err, "Fail to create directory [%v]", directory)
+	}
+
+	path := ArchivePath(directory, source, activityID)
+	err := writeFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(content))
+		return err
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "Fail to archive activity [%v]", activityID)
+	}
+	return path, nil
+}
+
+// IsArchivePath reports whether the file is a document archived by a registered source
+func IsArchivePath(path string) bool {
+	_, ok := archiveSource(path)
+	return ok
+}
+
+// ReadArchive decodes an activity archived by a registered source
+func ReadArchive(path string) (*Activity, error) {
+	source, ok := archiveSource(path)
+	if !ok {
+		return nil, errors.Errorf("Unsupported archive file [%v]", path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	activity, err := archiveDecoders[source](content)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Invalid archive file [%v]", path)
+	}
+	return activity, nil
+}
+
+// archiveSource returns the source of a file named by ArchivePath
+func archiveSource(path string) (string, bool) {
+	if FormatFromPath(path) != FormatJson {
+		return "", false
+	}
+	name := filepath.Base(path)
+	separator := strings.Index(name, "_")
+	if separator < 0 {
+		return "", false
+	}
+	source := name[:separator]
+	_, ok := archiveDecoders[source]
+	return source, ok
+}
@@ -0,0 +1,249 @@
+package API
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Supported file formats, named after their file extension
+const (
+	FormatGpx  = "gpx"
+	FormatTcx  = "tcx"
+	FormatFit  = "fit"
+	FormatJson = "json"
+
+	// Route formats, for mapping tools
+	FormatGeoJson = "geojson"
+	FormatKml     = "kml"
+
+	// Table formats, for data analysis
+	FormatCsv   = "csv"
+	FormatJsonl = "jsonl"
+)
+
+const DefaultOutputDirectory = "./activities"
+
+// PreferredFormat returns GPX when the activity has a GPS track, and TCX otherwise
+func PreferredFormat(activity *Activity) string {
+	if activity.HasPosition() {
+		return FormatGpx
+	}
+	return FormatTcx
+}
+
+// MarshalActivity encodes the activity in the given format
+func MarshalActivity(format string, activity *Activity) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := EncodeActivity(&buffer, format, activity); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// EncodeActivity writes the activity in the given format to w, XML and JSON documents
+// are written as they are encoded
+func EncodeActivity(w io.Writer, format string, activity *Activity) error {
+	var err error
+	switch format {
+	case FormatGpx:
+		if !activity.HasPosition() {
+			return errors.Errorf("Activity [%v] has no GPS track to write as GPX", activity.ID)
+		}
+		err = EncodeGpx(w, BuildGpx(activity))
+	case FormatTcx:
+		err = EncodeTcx(w, BuildTcx(activity))
+	case FormatGeoJson, FormatKml:
+		if !activity.HasPosition() {
+			return errors.Errorf("Activity [%v] has no GPS track to write as %v", activity.ID, format)
+		}
+		if format == FormatGeoJson {
+			err = EncodeGeoJSON(w, BuildGeoJSON(activity))
+		} else {
+			err = EncodeKml(w, BuildKml(activity))
+		}
+	case FormatCsv, FormatJsonl:
+		err = EncodeSamples(w, format, BuildSampleRows(activity))
+	case FormatFit:
+		err = EncodeFit(w, activity)
+	case FormatJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", " ")
+		err = encoder.Encode(activity)
+	default:
+		return errors.Errorf("Unsupported format [%v]", format)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "Fail to encode activity [%v] as %v", activity.ID, format)
+	}
+	return nil
+}
+
+// WriteActivityToFile writes the activity in the given format under directory and returns the file path
+func WriteActivityToFile(directory, format string, activity *Activity) (string, error) {
+	return writeActivityFile(directory, activity.ID, format, func(w io.Writer) error {
+		return EncodeActivity(w, format, activity)
+	})
+}
+
+// ReadActivityFromFile reads an activity previously written as JSON
+func ReadActivityFromFile(path string) (*Activity, error) {
+	if FormatFromPath(path) != FormatJson {
+		return nil, errors.Errorf("Unsupported file type [%v]", path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var activity Activity
+	if err = json.Unmarshal(content, &activity); err != nil {
+		return nil, errors.WithMessagef(err, "Invalid activity file [%v]", path)
+	}
+	return &activity, nil
+}
+
+// ReadActivitiesFromFile reads the activities of a JSON, GPX, TCX or FIT file, or of an
+// archived document. Activities of files named by ActivityPath keep their identifier, the
+// others are identified by their start time.
+func ReadActivitiesFromFile(path string) ([]*Activity, error) {
+	format := FormatFromPath(path)
+	if IsArchivePath(path) {
+		activity, err := ReadArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*Activity{activity}, nil
+	}
+	if format == FormatJson {
+		activity, err := ReadActivityFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*Activity{activity}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var activities []*Activity
+	switch format {
+	case FormatGpx:
+		activities, err = ParseGpx(file)
+	case FormatTcx:
+		activities, err = ParseTcx(file)
+	case FormatFit:
+		activities, err = ParseFit(file)
+	default:
+		return nil, errors.Errorf("Unsupported file type [%v]", path)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to read file [%v]", path)
+	}
+
+	if id := ActivityIDFromPath(path); len(id) > 0 && len(activities) == 1 {
+		activities[0].ID = id
+	}
+	return activities, nil
+}
+
+// ActivityPath returns the path an activity is written to
+func ActivityPath(directory, activityID, format string) string {
+	return filepath.Join(directory, fmt.Sprintf("activity_%v.%v", activityID, format))
+}
+
+// ActivityIDFromPath extracts the activity identifier from a path built by ActivityPath,
+// or returns an empty string when the file name does not follow this layout
+func ActivityIDFromPath(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if !strings.HasPrefix(name, "activity_") {
+		return ""
+	}
+	return strings.TrimPrefix(name, "activity_")
+}
+
+// activityFileFormats ranks the formats of activity files, from the most complete one
+var activityFileFormats = []string{FormatJson, FormatFit, FormatTcx, FormatGpx}
+
+// DistinctActivityFiles keeps a single file of those named alike but for their extension,
+// such as the conversions of an activity, in the first format of JSON, FIT, TCX and GPX.
+// The order of the paths is kept.
+func DistinctActivityFiles(paths []string) []string {
+	rank := func(path string) int {
+		for i, format := range activityFileFormats {
+			if FormatFromPath(path) == format {
+				return i
+			}
+		}
+		return len(activityFileFormats)
+	}
+
+	index := map[string]int{}
+	distinct := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(path, filepath.Ext(path))
+		i, ok := index[name]
+		if !ok {
+			index[name] = len(distinct)
+			distinct = append(distinct, path)
+		} else if rank(path) < rank(distinct[i]) {
+			distinct[i] = path
+		}
+	}
+	return distinct
+}
+
+// FormatFromPath returns the format of a file according to its extension
+func FormatFromPath(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// writeActivityFile streams the content written by encode to the activity path
+func writeActivityFile(directory, activityID, format string, encode func(w io.Writer) error) (string, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return "", errors.WithMessagef(err, "Fail to create directory [%v]", directory)
+	}
+
+	path := ActivityPath(directory, activityID, format)
+	if err := writeFile(path, encode); err != nil {
+		return "", errors.WithMessagef(err, "Fail to write file for id [%v]", activityID)
+	}
+	return path, nil
+}
+
+// writeFile streams the content written by encode to a temporary file, renamed to path
+// once complete so that a failure never leaves a truncated file
+func writeFile(path string, encode func(w io.Writer) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	err = encode(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package API
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDistinctActivityFiles(t *testing.T) {
+	paths := []string{
+		"activities/activity_1.fit",
+		"activities/activity_1.gpx",
+		"activities/activity_1.json",
+		"activities/activity_2.gpx",
+		"activities/activity_2.tcx",
+		"activities/run.gpx",
+		"archive/nike_1.json",
+	}
+	expected := []string{
+		"activities/activity_1.json",
+		"activities/activity_2.tcx",
+		"activities/run.gpx",
+		"archive/nike_1.json",
+	}
+	if distinct := DistinctActivityFiles(paths); !reflect.DeepEqual(distinct, expected) {
+		t.Errorf("Expected %v, got %v", expected, distinct)
+	}
+}
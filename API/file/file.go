@@ -0,0 +1,156 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runsync/API"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDirectory is the directory imported when FILE_SOURCE_DIR is not set
+const DefaultDirectory = "./import"
+
+func init() {
+	API.RegisterSource("file", NewSource)
+}
+
+// source imports the GPX, TCX, FIT and JSON files of a directory, such as files exported
+// from other applications, recorded by a watch or archived by another source
+type source struct {
+	directory string
+
+	// mutex guards the activities read, they are fetched concurrently
+	mutex      sync.Mutex
+	activities map[string]*API.Activity
+}
+
+func NewSource() (API.Source, error) {
+	directory := os.Getenv("FILE_SOURCE_DIR")
+	if len(directory) == 0 {
+		directory = DefaultDirectory
+	}
+
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to open import directory [%v]", directory)
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("Import path [%v] is not a directory", directory)
+	}
+
+	return &source{
+		directory:  directory,
+		activities: map[string]*API.Activity{},
+	}, nil
+}
+
+func (s *source) ListActivities(ctx context.Context, options API.ListOptions) ([]API.ActivityRef, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.list(options)
+}
+
+func (s *source) FetchActivity(ctx context.Context, id string) (*API.Activity, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.activities) == 0 {
+		if _, err := s.list(API.ListOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	activity, ok := s.activities[id]
+	if !ok {
+		return nil, errors.Errorf("Activity [%v] not found in [%v]", id, s.directory)
+	}
+	return activity, nil
+}
+
+// list reads the activities of the directory, the mutex must be held
+func (s *source) list(options API.ListOptions) ([]API.ActivityRef, error) {
+	entries, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to list import directory [%v]", s.directory)
+	}
+
+	// Files named alike are conversions of the same activity, only one of them is imported
+	var paths []string
+	modTimes := map[string]time.Time{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isSupported(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(s.directory, entry.Name())
+		paths = append(paths, path)
+		modTimes[path] = entry.ModTime().UTC()
+	}
+
+	refs := []API.ActivityRef{}
+	listed := map[string]string{}
+	for _, path := range API.DistinctActivityFiles(paths) {
+		activities, err := API.ReadActivitiesFromFile(path)
+		if err != nil {
+			log.WithError(err).Warnf("[file] file [%v] skipped", path)
+			continue
+		}
+
+		for i, activity := range activities {
+			// Archived activities are replayed as retrieved from their source
+			if !API.IsArchivePath(path) {
+				activity.ID = activityID(path, i, len(activities))
+				activity.Source = "file"
+			}
+			if other, ok := listed[activity.ID]; ok {
+				log.Warnf("[file] activity [%v] of file [%v] skipped, already read from [%v]", activity.ID, path, other)
+				continue
+			}
+			listed[activity.ID] = path
+			s.activities[activity.ID] = activity
+
+			if !options.Includes(activity.StartTime) {
+				continue
+			}
+			refs = append(refs, API.ActivityRef{
+				ID:        activity.ID,
+				StartTime: activity.StartTime,
+				UpdatedAt: modTimes[path],
+			})
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].StartTime.Before(refs[j].StartTime)
+	})
+	return refs, nil
+}
+
+func isSupported(name string) bool {
+	switch API.FormatFromPath(name) {
+	case API.FormatGpx, API.FormatTcx, API.FormatFit, API.FormatJson:
+		return true
+	default:
+		return false
+	}
+}
+
+// activityID identifies an activity by its file name, activities of files holding
+// several tracks or activities are suffixed by their position
+func activityID(path string, index, count int) string {
+	id := API.ActivityIDFromPath(path)
+	if len(id) == 0 {
+		id = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if count > 1 {
+		id = fmt.Sprintf("%v-%d", id, index+1)
+	}
+	return id
+}
@@ -0,0 +1,194 @@
+package API
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy sets how Do retries failed requests
+type RetryPolicy struct {
+	// MaxRetries is the number of attempts after the first one
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled on each retry and jittered
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts, a Retry-After asking for a longer wait
+	// is not honored and the response is returned
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by Do, RUNSYNC_HTTP_RETRIES overrides its number of retries
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+var (
+	policyOnce sync.Once
+	policy     RetryPolicy
+
+	jitterMutex sync.Mutex
+	jitter      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+type idempotentKey struct{}
+
+// Idempotent marks a request as safe to send again whatever its method, e.g. a token refresh
+func Idempotent(request *http.Request) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), idempotentKey{}, true))
+}
+
+// Do sends the request with the shared client of GetClient, retrying with DefaultRetryPolicy
+func Do(request *http.Request) (*http.Response, error) {
+	policyOnce.Do(func() {
+		policy = DefaultRetryPolicy
+		if value := os.Getenv("RUNSYNC_HTTP_RETRIES"); len(value) > 0 {
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				log.Warnf("[http] Invalid RUNSYNC_HTTP_RETRIES [%v], %d retries used", value, policy.MaxRetries)
+			} else {
+				policy.MaxRetries = retries
+			}
+		}
+	})
+	return DoWithPolicy(GetClient(), request, policy)
+}
+
+// DoWithPolicy sends the request, retrying on network errors and on responses with a
+// status meaning the server may succeed later (429, 502, 503, 504 and 500). Requests with
+// an idempotent method, or marked by Idempotent, are retried in every case; the others only
+// when the connection failed before the request was written, so that they are never
+// processed twice. A request with a body is retried only when its GetBody is set. The last
+// response or error is returned when the wait before a retry would exceed the request deadline.
+func DoWithPolicy(client *http.Client, request *http.Request, policy RetryPolicy) (*http.Response, error) {
+	ctx := request.Context()
+	idempotent := isIdempotent(request)
+	replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		current := request
+		if attempt > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			current = request.Clone(ctx)
+			current.Body = body
+		}
+
+		// Whether the request reached the server decides if a non idempotent one can be sent again
+		written := false
+		current = current.WithContext(httptrace.WithClientTrace(current.Context(), &httptrace.ClientTrace{
+			WroteHeaders: func() {
+				written = true
+			},
+		}))
+
+		response, err := client.Do(current)
+
+		retry := false
+		var delay time.Duration
+		if err != nil {
+			retry = ctx.Err() == nil && (idempotent || !written)
+		} else if retryableStatus(response.StatusCode) && idempotent {
+			retry = true
+			delay = retryAfter(response)
+		}
+		if !retry || !replayable || attempt >= policy.MaxRetries {
+			return response, err
+		}
+
+		if delay == 0 {
+			delay = backoff(policy, attempt)
+		}
+		if delay > policy.MaxDelay {
+			// The server asks for a longer wait than we are ready to, let the caller know
+			return response, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			// The request would time out while waiting, the last response tells the caller why
+			return response, err
+		}
+
+		reason := "connection failed"
+		fields := log.Fields{}
+		if err != nil {
+			fields[log.ErrorKey] = err
+		} else {
+			reason = response.Status
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+		log.WithFields(fields).Warnf("[http] %v %v: %v, retry %d/%d in %v", request.Method, request.URL.Redacted(), reason, attempt+1, policy.MaxRetries, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func isIdempotent(request *http.Request) bool {
+	if marked, _ := request.Context().Value(idempotentKey{}).(bool); marked {
+		return true
+	}
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay asked by the Retry-After header, in seconds or as a date,
+// or 0 when there is none
+func retryAfter(response *http.Response) time.Duration {
+	value := response.Header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// backoff returns a random delay between half and the whole of the base delay doubled on
+// each attempt
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	ceiling := policy.BaseDelay << uint(attempt)
+	if ceiling > policy.MaxDelay || ceiling <= 0 {
+		ceiling = policy.MaxDelay
+	}
+
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+	return ceiling/2 + time.Duration(jitter.Int63n(int64(ceiling/2)+1))
+}
@@ -0,0 +1,35 @@
+package API
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoReturnsResponseWhenRetryExceedsDeadline(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := DoWithPolicy(server.Client(), request, DefaultRetryPolicy)
+	if err != nil {
+		t.Fatalf("Expected the rate limited response, got %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests || requests != 1 {
+		t.Errorf("Expected a single request answered with 429, got %d requests and %v", requests, response.Status)
+	}
+}
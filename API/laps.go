@@ -0,0 +1,142 @@
+package API
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// Lap triggers
+const (
+	LapTriggerManual   = "manual"
+	LapTriggerDistance = "distance"
+)
+
+// Lap distances in meters
+const (
+	LapKilometer = 1000.0
+	LapMile      = 1609.344
+)
+
+// ParseLapDistance reads a lap split: km, mile or none (0)
+func ParseLapDistance(value string) (float64, error) {
+	switch strings.ToLower(value) {
+	case "km", "kilometer":
+		return LapKilometer, nil
+	case "mi", "mile":
+		return LapMile, nil
+	case "", "none":
+		return 0, nil
+	default:
+		return 0, errors.Errorf("Invalid lap split [%v], expecting km, mile or none", value)
+	}
+}
+
+// ApplyLaps splits the activity every distance meters, unless it has recorded laps
+// or distance is 0
+func ApplyLaps(activity *Activity, distance float64) {
+	if len(activity.Laps) > 0 || distance <= 0 {
+		return
+	}
+	activity.Laps = SplitLaps(activity, distance)
+}
+
+// SplitLaps returns the laps of the activity split every distance meters, the last
+// lap holding the remaining distance
+func SplitLaps(activity *Activity, distance float64) []Lap {
+	var ends []time.Time
+	var total float64
+	next := distance
+	for _, sample := range activity.Samples(StreamDistance) {
+		for sample.Value > 0 && total+sample.Value >= next {
+			ratio := (next - total) / sample.Value
+			ends = append(ends, sample.Start.Add(time.Duration(ratio*float64(sample.End.Sub(sample.Start)))))
+			next += distance
+		}
+		total += sample.Value
+	}
+	return LapsAt(activity, ends, LapTriggerDistance)
+}
+
+// LapsAt returns the laps of the activity ending at the given times, the last lap
+// ending with the activity. The laps ending at the given times have the trigger, the
+// remaining one is ended manually by the end of the activity.
+func LapsAt(activity *Activity, ends []time.Time, trigger string) []Lap {
+	distances := activity.Samples(StreamDistance)
+	end := activity.EndTime()
+
+	var laps []Lap
+	start := activity.StartTime
+	for i, lapEnd := range append(ends, end) {
+		if !lapEnd.After(start) {
+			continue
+		}
+		lapTrigger := trigger
+		if i == len(ends) || lapEnd.After(end) {
+			lapEnd = end
+			lapTrigger = LapTriggerManual
+		}
+		laps = append(laps, Lap{
+			StartTime: start,
+			Duration:  lapEnd.Sub(start),
+			Distance:  distanceBetween(distances, start, lapEnd),
+			Trigger:   lapTrigger,
+		})
+		start = lapEnd
+	}
+	return laps
+}
+
+// ActiveDuration returns the duration of the lap without the pauses overlapping it
+func (l Lap) ActiveDuration(pauses []Pause) time.Duration {
+	end := l.StartTime.Add(l.Duration)
+	active := l.Duration
+	for _, pause := range pauses {
+		from, to := pause.Start, pause.End
+		if from.Before(l.StartTime) {
+			from = l.StartTime
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			active -= to.Sub(from)
+		}
+	}
+	return active
+}
+
+// distanceBetween sums the distance samples over [from, to), samples overlapping a
+// bound are counted in proportion of the overlap
+func distanceBetween(samples []Sample, from, to time.Time) float64 {
+	var distance float64
+	for _, sample := range samples {
+		if !sample.End.After(sample.Start) {
+			if !sample.Start.Before(from) && sample.Start.Before(to) {
+				distance += sample.Value
+			}
+			continue
+		}
+		start, end := sample.Start, sample.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			distance += sample.Value * float64(end.Sub(start)) / float64(sample.End.Sub(sample.Start))
+		}
+	}
+	return distance
+}
+
+// lapIndex returns the index of the lap holding t, times after the last lap belong to it
+func lapIndex(laps []Lap, t time.Time) int {
+	for i := len(laps) - 1; i > 0; i-- {
+		if !t.Before(laps[i].StartTime) {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package API
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitLaps(t *testing.T) {
+	// 2500m covered at 5 m/s, paused for 100 seconds in the middle of the second kilometer
+	activity := &Activity{
+		StartTime: at(0),
+		Pauses:    []Pause{{Start: at(300), End: at(400)}},
+	}
+	distance := Stream{Type: StreamDistance}
+	for i := 0; i < 5; i++ {
+		start := 100 * i
+		if i >= 3 {
+			start += 100
+		}
+		distance.Samples = append(distance.Samples, Sample{Start: at(start), End: at(start + 100), Value: 500})
+	}
+	activity.Streams = []Stream{distance}
+
+	expected := []Lap{
+		{StartTime: at(0), Duration: 200 * time.Second, Distance: 1000, Trigger: LapTriggerDistance},
+		{StartTime: at(200), Duration: 300 * time.Second, Distance: 1000, Trigger: LapTriggerDistance},
+		{StartTime: at(500), Duration: 100 * time.Second, Distance: 500, Trigger: LapTriggerManual},
+	}
+	laps := SplitLaps(activity, LapKilometer)
+	if len(laps) != len(expected) {
+		t.Fatalf("Expected %d laps, got %+v", len(expected), laps)
+	}
+	for i, lap := range expected {
+		if !laps[i].StartTime.Equal(lap.StartTime) || laps[i].Duration != lap.Duration || laps[i].Distance != lap.Distance || laps[i].Trigger != lap.Trigger {
+			t.Errorf("Lap %d: expected %+v, got %+v", i+1, lap, laps[i])
+		}
+	}
+
+	if active := laps[1].ActiveDuration(activity.Pauses); active != 200*time.Second {
+		t.Errorf("Expected the second lap to be active for 200s, got %v", active)
+	}
+}
+
+func TestTcxLapsExcludePauses(t *testing.T) {
+	tcx := BuildTcx(fitTestActivity())
+	laps := tcx.Activities.Activities[0].Laps
+	if len(laps) != 2 {
+		t.Fatalf("Expected 2 laps, got %d", len(laps))
+	}
+
+	// The second lap is paused for 5 of its 20 seconds
+	for i, expected := range []struct{ time, speed float32 }{{20, 3}, {15, 4}} {
+		if laps[i].TotalTimeSeconds != expected.time || laps[i].Extensions.LX.AvgSpeed != expected.speed {
+			t.Errorf("Lap %d: expected %vs at %v m/s, got %vs at %v m/s", i+1, expected.time, expected.speed, laps[i].TotalTimeSeconds, laps[i].Extensions.LX.AvgSpeed)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package API
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultNameTemplate names the activities which were not named by their source
+const DefaultNameTemplate = "{{.Weekday}} {{.SportName}}{{with .SourceName}} - {{.}}{{end}}"
+
+// NameData holds the fields available to naming templates, times are local
+type NameData struct {
+	Weekday   string
+	TimeOfDay string
+	Date      string
+	Time      string
+	Sport     string
+	SportName string
+	// Distance in kilometers
+	Distance float64
+	// Duration formatted as h:mm:ss or m:ss
+	Duration string
+	// Pace per kilometer formatted as m:ss
+	Pace     string
+	Location string
+	// SourceName is the short name of the application which recorded the activity, empty
+	// when unknown
+	SourceName string
+	Tags       map[string]string
+}
+
+// Namer names activities from a text/template, see NameData for the available fields
+type Namer struct {
+	template *template.Template
+	// force renames activities which already have a name
+	force bool
+}
+
+// NewNamer parses a naming template, an empty text selects DefaultNameTemplate which
+// only names the activities without a name
+func NewNamer(text string) (*Namer, error) {
+	force := true
+	if len(text) == 0 {
+		text = DefaultNameTemplate
+		force = false
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Invalid name template %q", text)
+	}
+	return &Namer{
+		template: tmpl,
+		force:    force,
+	}, nil
+}
+
+// Apply sets the name of the activity
+func (n *Namer) Apply(activity *Activity) error {
+	if len(activity.Name) > 0 && !n.force {
+		return nil
+	}
+
+	name, err := n.Name(activity)
+	if err != nil {
+		return err
+	}
+	activity.Name = name
+	return nil
+}
+
+// Name renders the template for the activity
+func (n *Namer) Name(activity *Activity) (string, error) {
+	return render(n.template, activity)
+}
+
+// RenderTemplate renders text as a naming template for the activity, text without
+// action is returned as is
+func RenderTemplate(text string, activity *Activity) (string, error) {
+	if !strings.Contains(text, "{{") || activity == nil {
+		return text, nil
+	}
+
+	tmpl, err := template.New("text").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", errors.WithMessagef(err, "Invalid template %q", text)
+	}
+	return render(tmpl, activity)
+}
+
+func render(tmpl *template.Template, activity *Activity) (string, error) {
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, NewNameData(activity)); err != nil {
+		return "", errors.WithMessagef(err, "Fail to name activity [%v]", activity.ID)
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+func NewNameData(activity *Activity) NameData {
+	start := activity.StartTime.Local()
+	distance := activity.Distance() / 1000
+
+	data := NameData{
+		Weekday:    start.Weekday().String(),
+		TimeOfDay:  timeOfDay(start),
+		Date:       start.Format("2006-01-02"),
+		Time:       start.Format("15:04"),
+		Sport:      activity.Sport,
+		SportName:  sportName(activity.Sport),
+		Distance:   distance,
+		Duration:   formatDuration(activity.Duration),
+		Location:   location(activity),
+		SourceName: SourceName(activity.Source),
+		Tags:       activity.Tags,
+	}
+	if distance > 0 {
+		data.Pace = formatDuration(time.Duration(float64(activity.Duration) / distance))
+	}
+	return data
+}
+
+// SourceName returns the short name of the application behind a source, or an empty
+// string for sources such as files which do not tell where the activity was recorded
+func SourceName(source string) string {
+	switch source {
+	case "nike":
+		return "NRC"
+	default:
+		return ""
+	}
+}
+
+func timeOfDay(t time.Time) string {
+	switch hour := t.Hour(); {
+	case hour >= 5 && hour < 11:
+		return "Morning"
+	case hour >= 11 && hour < 14:
+		return "Lunch"
+	case hour >= 14 && hour < 18:
+		return "Afternoon"
+	case hour >= 18 && hour < 22:
+		return "Evening"
+	default:
+		return "Night"
+	}
+}
+
+func sportName(sport string) string {
+	switch sport {
+	case SportRunning:
+		return "run"
+	case SportBiking:
+		return "ride"
+	default:
+		return "activity"
+	}
+}
+
+// location returns the location tag of the activity, or the coordinates of its start
+func location(activity *Activity) string {
+	if location, ok := activity.Tags["location"]; ok {
+		return location
+	}
+	latitudes := activity.Samples(StreamLatitude)
+	longitudes := activity.Samples(StreamLongitude)
+	if len(latitudes) == 0 || len(longitudes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.4f,%.4f", latitudes[0].Value, longitudes[0].Value)
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	hours := int(d / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	seconds := int(d % time.Minute / time.Second)
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
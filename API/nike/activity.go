@@ -5,30 +5,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"runsync/API"
 	"strconv"
-	"time"
 )
 
 const (
-	getActivitiesByTimeEndpoint = "sport/v3/me/activities/after_time/"
-	getActivitiesByIdEndpoint   = "sport/v3/me/activity/%s?metrics=ALL"
+	getActivitiesByTimeEndpoint  = "sport/v3/me/activities/after_time/"
+	getActivitiesAfterIdEndpoint = "sport/v3/me/activities/after_id/"
+	getActivitiesByIdEndpoint    = "sport/v3/me/activity/%s?metrics=ALL"
+
+	// DefaultPageSize is the number of activities requested per page when not set
+	DefaultPageSize = 30
 )
 
 type activities struct {
 	Activities []activity `json:"activities"`
 	Paging     paging     `json:"paging"`
 }
-
 type activity struct {
-	ID               string    `json:"id"`
-	Type             string    `json:"type"`
-	StartEpoch       int64     `json:"start_epoch_ms"`
-	ActivityDuration int64     `json:"active_duration_ms"`
-	Summaries        []summary `json:"summaries"`
-	MetricTypes      []string  `json:"metric_types"`
-	Metrics          []metric  `json:"metrics"`
+	ID               string            `json:"id"`
+	Type             string            `json:"type"`
+	StartEpoch       int64             `json:"start_epoch_ms"`
+	ActivityDuration int64             `json:"active_duration_ms"`
+	LastModified     int64             `json:"last_modified"`
+	Tags             map[string]string `json:"tags"`
+	Summaries        []summary         `json:"summaries"`
+	MetricTypes      []string          `json:"metric_types"`
+	Metrics          []metric          `json:"metrics"`
+	Moments          []moment          `json:"moments"`
 }
 
 type summary struct {
@@ -49,59 +57,162 @@ type metricValue struct {
 	Value float64 `json:"value"`
 }
 
+// moment is an event recorded during the activity, e.g. a lap or a pause
+type moment struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// paging holds the cursors of the next page, both are empty on the last page
 type paging struct {
 	AfterTime int64  `json:"after_time"`
 	AfterID   string `json:"after_id"`
 }
 
-// Get activities for the last 14 days
-func GetActivities(ctx context.Context, accessToken string) ([]activity, error) {
-	tm := time.Now().AddDate(0, 0, -100).Unix() * 1000
+// PageOptions restricts the activities listed by a Paginator
+type PageOptions struct {
+	// AfterTime lists activities started after (epoch in milliseconds, 0 for the whole history)
+	AfterTime int64
+	// AfterID lists activities recorded after this activity, it takes precedence over AfterTime
+	AfterID string
+	// BeforeTime excludes activities started after (epoch in milliseconds, 0 for no bound)
+	BeforeTime int64
+	// PageSize is the number of activities requested per page, DefaultPageSize when 0
+	PageSize int
+}
 
-	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
-	defer cancel()
+// Paginator walks through the pages of activities, following the after_id cursor when
+// Nike returns one and the after_time cursor otherwise
+type Paginator struct {
+	accessToken string
+	options     PageOptions
+	endpoint    string
+	// requested holds the endpoints already requested, a cursor pointing to one of them
+	// does not advance
+	requested map[string]bool
+}
 
-	var activityIds []activity = make([]activity, 0)
-	for ; tm > 0; {
-		request, err := http.NewRequest(
-			http.MethodGet,
-			baseURL+getActivitiesByTimeEndpoint+strconv.FormatInt(tm, 10),
-			nil)
-		if err != nil {
-			return nil, err
-		}
+func NewPaginator(accessToken string, options PageOptions) *Paginator {
+	if options.PageSize <= 0 {
+		options.PageSize = DefaultPageSize
+	}
+	p := &Paginator{
+		accessToken: accessToken,
+		options:     options,
+		requested:   map[string]bool{},
+	}
+	p.endpoint = p.next(paging{AfterTime: options.AfterTime, AfterID: options.AfterID})
+	return p
+}
 
-		header := request.Header
-		header.Set("Authorization", "Bearer "+accessToken)
+// HasNext reports whether another page is available
+func (p *Paginator) HasNext() bool {
+	return len(p.endpoint) > 0
+}
 
-		response, err := API.GetClient().Do(request)
-		if err != nil {
-			return nil, errors.WithMessage(err, "Fail to connect to Nike API")
+// Next requests the next page and returns its activities started before the BeforeTime bound
+func (p *Paginator) Next(ctx context.Context) ([]activity, error) {
+	if !p.HasNext() {
+		return nil, errors.New("No more activities")
+	}
+
+	endpoint := p.endpoint
+	p.requested[endpoint] = true
+	data, err := p.get(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p.endpoint = ""
+	if len(data.Activities) > 0 {
+		p.endpoint = p.next(data.Paging)
+		if p.requested[p.endpoint] {
+			log.Warnf("[nike] Paging cursor does not advance from [%v], listing stopped", endpoint)
+			p.endpoint = ""
 		}
+	}
 
-		defer response.Body.Close()
-		if response.StatusCode != http.StatusOK {
-			return nil, errors.WithMessage(errors.New(response.Status), "Fail to get activities")
+	// Pages are ordered by start time, the listing stops with the first one going past
+	// the BeforeTime bound, whichever cursor it follows
+	page := make([]activity, 0, len(data.Activities))
+	for _, a := range data.Activities {
+		if p.options.BeforeTime > 0 && a.StartEpoch > p.options.BeforeTime {
+			p.endpoint = ""
+			continue
 		}
+		page = append(page, a)
+	}
+	return page, nil
+}
 
-		var data activities
-		decoder := json.NewDecoder(response.Body)
-		if err = decoder.Decode(&data); err != nil {
-			return nil, errors.WithMessage(err, API.ErrInvalidLoginResponse.Error())
+// next returns the endpoint of the page designated by the cursors, or an empty string
+// when there is none or when it starts after the BeforeTime bound
+func (p *Paginator) next(cursors paging) string {
+	var endpoint string
+	switch {
+	case len(cursors.AfterID) > 0:
+		endpoint = getActivitiesAfterIdEndpoint + url.PathEscape(cursors.AfterID)
+	case cursors.AfterTime > 0 || len(p.requested) == 0:
+		if p.options.BeforeTime > 0 && cursors.AfterTime > p.options.BeforeTime {
+			return ""
 		}
+		endpoint = getActivitiesByTimeEndpoint + strconv.FormatInt(cursors.AfterTime, 10)
+	default:
+		return ""
+	}
+	return baseURL + endpoint + "?limit=" + strconv.Itoa(p.options.PageSize)
+}
+
+func (p *Paginator) get(ctx context.Context, endpoint string) (*activities, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+p.accessToken)
+
+	response, err := API.Do(request)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Fail to connect to Nike API")
+	}
+	defer response.Body.Close()
 
-		activityIds = append(activityIds, data.Activities...)
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(errors.New(response.Status), "Fail to get activities")
+	}
 
-		tm = data.Paging.AfterTime
+	var data activities
+	if err = json.NewDecoder(response.Body).Decode(&data); err != nil {
+		return nil, errors.WithMessage(err, "Invalid activities response")
 	}
-	return activityIds, nil
+	return &data, nil
 }
 
-func GetActivity(ctx context.Context, accessToken string, activityId string) (*activity, error) {
+// GetActivities lists every activity matching the options, page after page
+func GetActivities(ctx context.Context, accessToken string, options PageOptions) ([]activity, error) {
+	result := make([]activity, 0)
+	paginator := NewPaginator(accessToken, options)
+	for paginator.HasNext() {
+		page, err := paginator.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page...)
+	}
+	return result, nil
+}
+
+// GetActivityContent returns the activity document as sent by Nike, with the fields
+// not decoded into activity
+func GetActivityContent(ctx context.Context, accessToken string, activityId string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
 	defer cancel()
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
 		fmt.Sprintf(baseURL+getActivitiesByIdEndpoint, activityId),
 		nil)
@@ -113,7 +224,7 @@ func GetActivity(ctx context.Context, accessToken string, activityId string) (*a
 	header := request.Header
 	header.Set("Authorization", "Bearer "+accessToken)
 
-	response, err := API.GetClient().Do(request)
+	response, err := API.Do(request)
 	if err != nil {
 		return nil, errors.WithMessage(err, "Fail to connect to Nike API")
 	}
@@ -123,12 +234,21 @@ func GetActivity(ctx context.Context, accessToken string, activityId string) (*a
 		return nil, errors.WithMessagef(errors.New(response.Status), "Fail to get activity with id %v", activityId)
 	}
 
-	var data activity
-	decoder := json.NewDecoder(response.Body)
-
-	if err = decoder.Decode(&data); err != nil {
-		return nil, errors.WithMessage(err, API.ErrInvalidLoginResponse.Error())
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to read activity with id %v", activityId)
 	}
+	return content, nil
+}
 
-	return &data, nil
+// ParseActivity converts an activity document as sent by Nike, e.g. an archived one
+func ParseActivity(content []byte) (*API.Activity, error) {
+	var data activity
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	if len(data.ID) == 0 {
+		return nil, errors.New("Not a Nike Run Club activity")
+	}
+	return ToActivity(data), nil
 }
@@ -0,0 +1,61 @@
+package nike
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPaginatorStopsAfterBeforeTime(t *testing.T) {
+	// Each page points to the next one through the after_id cursor
+	pages := map[string]activities{
+		"/sport/v3/me/activities/after_time/0": {
+			Activities: []activity{{ID: "1", StartEpoch: 1000}, {ID: "2", StartEpoch: 2000}},
+			Paging:     paging{AfterID: "2"},
+		},
+		"/sport/v3/me/activities/after_id/2": {
+			Activities: []activity{{ID: "3", StartEpoch: 3000}, {ID: "4", StartEpoch: 4000}},
+			Paging:     paging{AfterID: "4"},
+		},
+		"/sport/v3/me/activities/after_id/4": {
+			Activities: []activity{{ID: "5", StartEpoch: 5000}},
+			Paging:     paging{AfterID: "5"},
+		},
+		"/sport/v3/me/activities/after_id/5": {},
+	}
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	defer func(url string) { baseURL = url }(baseURL)
+	baseURL = server.URL + "/"
+
+	result, err := GetActivities(context.Background(), "token", PageOptions{BeforeTime: 3500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, a := range result {
+		ids = append(ids, a.ID)
+	}
+	if expected := []string{"1", "2", "3"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected activities %v, got %v", expected, ids)
+	}
+	if len(requested) != 2 || !strings.HasSuffix(requested[1], "after_id/2") {
+		t.Errorf("Expected the listing to stop after the page going past the bound, requested %v", requested)
+	}
+}
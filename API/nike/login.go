@@ -39,7 +39,7 @@ func GetBearer(ctx context.Context, clientID, refreshToken string) (*string, err
 	}
 	body := bytes.NewReader(b)
 
-	request, err := http.NewRequest(http.MethodPost, baseURL+getTokenEndpoint, body)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+getTokenEndpoint, body)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +47,8 @@ func GetBearer(ctx context.Context, clientID, refreshToken string) (*string, err
 	header := request.Header
 	header.Set("Content-Type", "application/json")
 
-	response, err := API.GetClient().Do(request)
+	// Refreshing the token twice is harmless
+	response, err := API.Do(API.Idempotent(request))
 	if err != nil {
 		return nil, errors.WithMessage(err, "Failed to connect to Nike API")
 	}
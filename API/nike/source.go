@@ -0,0 +1,128 @@
+package nike
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"runsync/API"
+	"sync"
+	"time"
+)
+
+func init() {
+	API.RegisterSource("nike", NewSource)
+	API.RegisterArchive("nike", ParseActivity)
+}
+
+// source retrieves runs from Nike Run Club, archiving the documents it receives so that
+// they can be converted again offline
+type source struct {
+	clientID     string
+	refreshToken string
+	// archive is the directory documents are archived to, empty when archiving is disabled
+	archive string
+
+	// mutex guards the access token, activities are fetched concurrently
+	mutex       sync.Mutex
+	accessToken *string
+}
+
+func NewSource() (API.Source, error) {
+	clientID := os.Getenv("NIKE_CLIENT_ID")
+	refreshToken := os.Getenv("NIKE_REFRESH_TOKEN")
+
+	if len(clientID) == 0 || len(refreshToken) == 0 {
+		return nil, errors.New("Please set your Nike Run Club application parameters in .env")
+	}
+
+	return &source{
+		clientID:     clientID,
+		refreshToken: refreshToken,
+		archive:      API.ArchiveDirectory(),
+	}, nil
+}
+
+func (s *source) DisableArchive() {
+	s.archive = ""
+}
+
+func (s *source) bearer(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.accessToken == nil {
+		accessToken, err := GetBearer(ctx, s.clientID, s.refreshToken)
+		if err != nil {
+			return "", errors.WithMessage(err, "Fail to get bearer from Nike Run Club")
+		}
+		log.Infof("[nike] Bearer retrieved with success")
+		s.accessToken = accessToken
+	}
+	return *s.accessToken, nil
+}
+
+func (s *source) ListActivities(ctx context.Context, options API.ListOptions) ([]API.ActivityRef, error) {
+	accessToken, err := s.bearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pageOptions := PageOptions{AfterID: options.AfterID}
+	if !options.Since.IsZero() {
+		pageOptions.AfterTime = options.Since.UnixNano() / int64(time.Millisecond)
+	}
+	if !options.Until.IsZero() {
+		pageOptions.BeforeTime = options.Until.UnixNano() / int64(time.Millisecond)
+	}
+
+	activities, err := GetActivities(ctx, accessToken, pageOptions)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to get activities from Nike Run Club")
+	}
+
+	// Filter to keep runs activities only
+	refs := []API.ActivityRef{}
+	for _, activity := range activities {
+		if !options.Includes(epochToTime(activity.StartEpoch)) {
+			continue
+		}
+		if activity.Type == "run" {
+			refs = append(refs, API.ActivityRef{
+				ID:        activity.ID,
+				StartTime: epochToTime(activity.StartEpoch),
+				UpdatedAt: epochToTime(activity.LastModified),
+			})
+		} else {
+			log.Infof("[nike] activity [%v] skipped cause it has type [%v]", activity.ID, activity.Type)
+		}
+	}
+	return refs, nil
+}
+
+func (s *source) FetchActivity(ctx context.Context, id string) (*API.Activity, error) {
+	accessToken, err := s.bearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("[nike] Retrieve run details for [%v]", id)
+	content, err := GetActivityContent(ctx, accessToken, id)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to get run from Nike Run Club for [%v]", id)
+	}
+
+	if len(s.archive) > 0 {
+		if path, err := API.WriteArchive(s.archive, "nike", id, content); err != nil {
+			log.WithError(err).Warnf("[nike] Fail to archive run [%v]", id)
+		} else {
+			log.Debugf("[nike] Run [%v] archived to %v", id, path)
+		}
+	}
+
+	activity, err := ParseActivity(content)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Invalid run from Nike Run Club for [%v]", id)
+	}
+	return activity, nil
+}
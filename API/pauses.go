@@ -0,0 +1,82 @@
+package API
+
+import (
+	"sort"
+	"time"
+)
+
+// PauseGap is the minimum gap between two samples considered as a pause when the
+// source did not record the pauses
+const PauseGap = 30 * time.Second
+
+// ActivityPauses returns the pauses recorded by the source, or the gaps longer than
+// PauseGap between the samples of the activity
+func ActivityPauses(activity *Activity) []Pause {
+	if len(activity.Pauses) > 0 {
+		return activity.Pauses
+	}
+	return DetectPauses(activity, PauseGap)
+}
+
+// DetectPauses returns the gaps longer than gap between the positions of the activity,
+// or between its speed or distance samples when it has no GPS track
+func DetectPauses(activity *Activity, gap time.Duration) []Pause {
+	var samples []Sample
+	for _, t := range []StreamType{StreamLatitude, StreamSpeed, StreamDistance} {
+		if samples = activity.Samples(t); len(samples) > 0 {
+			break
+		}
+	}
+
+	var pauses []Pause
+	for i := 1; i < len(samples); i++ {
+		previous := samples[i-1].End
+		if samples[i].Start.Sub(previous) > gap {
+			pauses = append(pauses, Pause{Start: previous, End: samples[i].Start})
+		}
+	}
+	return pauses
+}
+
+// SortPauses orders pauses by start time and merges the overlapping ones
+func SortPauses(pauses []Pause) []Pause {
+	sort.Slice(pauses, func(i, j int) bool {
+		return pauses[i].Start.Before(pauses[j].Start)
+	})
+
+	var merged []Pause
+	for _, pause := range pauses {
+		if !pause.End.After(pause.Start) {
+			continue
+		}
+		if n := len(merged); n > 0 && !pause.Start.After(merged[n-1].End) {
+			if pause.End.After(merged[n-1].End) {
+				merged[n-1].End = pause.End
+			}
+			continue
+		}
+		merged = append(merged, pause)
+	}
+	return merged
+}
+
+// pausedBetween reports whether a pause starts after from and before to, i.e. whether
+// points at from and to belong to different segments
+func pausedBetween(pauses []Pause, from, to time.Time) bool {
+	for _, pause := range pauses {
+		if !pause.Start.Before(from) && pause.Start.Before(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPaused reports whether t is strictly inside a pause
+func isPaused(pauses []Pause, t time.Time) bool {
+	for _, pause := range pauses {
+		if t.After(pause.Start) && t.Before(pause.End) {
+			return true
+		}
+	}
+	return false
+}
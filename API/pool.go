@@ -0,0 +1,46 @@
+package API
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is the number of activities processed at once when not set
+const DefaultConcurrency = 4
+
+// RunTasks runs task for each index of [0, count), with at most concurrency tasks running at
+// once, and returns the error of each task at its index. A failed task does not stop the
+// others, but once ctx is done the tasks not started yet fail with the context error.
+// Tasks store their results by index, so that they are ordered whatever the completion order.
+func RunTasks(ctx context.Context, concurrency, count int, task func(ctx context.Context, index int) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > count {
+		concurrency = count
+	}
+
+	errs := make([]error, count)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				errs[i] = task(ctx, i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errs
+}
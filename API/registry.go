@@ -0,0 +1,129 @@
+package API
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+// Source is a platform activities are retrieved from (e.g. Nike Run Club)
+type Source interface {
+	// ListActivities returns references to the activities to synchronize
+	ListActivities(ctx context.Context, options ListOptions) ([]ActivityRef, error)
+	// FetchActivity retrieves an activity and converts it to the canonical model
+	FetchActivity(ctx context.Context, id string) (*Activity, error)
+}
+
+// ArchivingSource is a source saving the documents it retrieves, see WriteArchive
+type ArchivingSource interface {
+	Source
+	// DisableArchive stops writing the retrieved documents, e.g. for a dry run
+	DisableArchive()
+}
+
+// ListOptions restricts the activities listed by a source
+type ListOptions struct {
+	// Since excludes activities started before, the whole history is listed when zero
+	Since time.Time
+	// Until excludes activities started after, no upper bound when zero
+	Until time.Time
+	// AfterID resumes the listing after the given activity, for sources supporting it
+	AfterID string
+}
+
+// Includes reports whether an activity started at t is within the options range
+func (o ListOptions) Includes(t time.Time) bool {
+	if !o.Since.IsZero() && t.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && t.After(o.Until) {
+		return false
+	}
+	return true
+}
+
+// ActivityRef identifies an activity on its source without its details
+type ActivityRef struct {
+	ID        string
+	StartTime time.Time
+	UpdatedAt time.Time
+}
+
+// Sink is a platform activities are pushed to (e.g. Strava)
+type Sink interface {
+	// Push sends the activity file found at path to the platform, activity describes
+	// the file content when known and is nil otherwise
+	Push(ctx context.Context, path string, activity *Activity) (*PushResult, error)
+}
+
+// PushResult identifies an activity pushed to a sink
+type PushResult struct {
+	// UploadID identifies the upload on the sink, when it processes files asynchronously
+	UploadID string
+	// ActivityID identifies the activity created on the sink
+	ActivityID string
+	// Duplicate is set when the sink already had the activity, ActivityID is then the existing one
+	Duplicate bool
+}
+
+type SourceFactory func() (Source, error)
+
+type SinkFactory func() (Sink, error)
+
+var (
+	sources = map[string]SourceFactory{}
+	sinks   = map[string]SinkFactory{}
+)
+
+// RegisterSource makes a source available by name, it is meant to be called from an init function
+func RegisterSource(name string, factory SourceFactory) {
+	if _, exists := sources[name]; exists {
+		panic("source already registered: " + name)
+	}
+	sources[name] = factory
+}
+
+// RegisterSink makes a sink available by name, it is meant to be called from an init function
+func RegisterSink(name string, factory SinkFactory) {
+	if _, exists := sinks[name]; exists {
+		panic("sink already registered: " + name)
+	}
+	sinks[name] = factory
+}
+
+func NewSource(name string) (Source, error) {
+	factory, ok := sources[name]
+	if !ok {
+		return nil, errors.Errorf("Unknown source [%v], available sources are %v", name, Sources())
+	}
+	return factory()
+}
+
+func NewSink(name string) (Sink, error) {
+	factory, ok := sinks[name]
+	if !ok {
+		return nil, errors.Errorf("Unknown sink [%v], available sinks are %v", name, Sinks())
+	}
+	return factory()
+}
+
+// Sources returns the names of the registered sources
+func Sources() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sinks returns the names of the registered sinks
+func Sinks() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,128 @@
+package API
+
+import (
+	"sort"
+	"time"
+)
+
+// Interpolation selects how a stream is evaluated between its samples
+type Interpolation int
+
+const (
+	// InterpolateStep holds the value of the last sample started
+	InterpolateStep Interpolation = iota
+	// InterpolateNearest takes the value of the closest sample
+	InterpolateNearest
+	// InterpolateLinear interpolates between the middles of the surrounding samples
+	InterpolateLinear
+)
+
+// DefaultInterpolation returns the interpolation suiting the metric of a stream:
+// continuous measures are interpolated, the others hold their last value
+func DefaultInterpolation(t StreamType) Interpolation {
+	switch t {
+	case StreamLatitude, StreamLongitude, StreamElevation, StreamTemperature:
+		return InterpolateLinear
+	case StreamHeartRate:
+		return InterpolateNearest
+	default:
+		return InterpolateStep
+	}
+}
+
+// Timeline returns the start times of the samples of the first of types the activity has
+func Timeline(activity *Activity, types ...StreamType) []time.Time {
+	for _, t := range types {
+		samples := activity.Samples(t)
+		if len(samples) == 0 {
+			continue
+		}
+		times := make([]time.Time, len(samples))
+		for i, sample := range samples {
+			times[i] = sample.Start
+		}
+		return times
+	}
+	return nil
+}
+
+// Resample evaluates the samples at each time, a value is nil before the first sample.
+// Samples must be ordered by start time.
+func Resample(samples []Sample, times []time.Time, interpolation Interpolation) []*float64 {
+	values := make([]*float64, len(times))
+	if len(samples) == 0 {
+		return values
+	}
+
+	for i, t := range times {
+		// next is the first sample started after t
+		next := sort.Search(len(samples), func(j int) bool {
+			return samples[j].Start.After(t)
+		})
+		if next == 0 {
+			continue
+		}
+		current := samples[next-1]
+
+		var value float64
+		switch {
+		case next == len(samples) || !t.After(current.End) || interpolation == InterpolateStep:
+			value = current.Value
+		case interpolation == InterpolateNearest:
+			value = current.Value
+			if samples[next].Start.Sub(t) < t.Sub(current.End) {
+				value = samples[next].Value
+			}
+		default:
+			value = interpolate(current, samples[next], t)
+		}
+		values[i] = &value
+	}
+	return values
+}
+
+// ResampleStream evaluates a stream of the activity at each time with its default interpolation
+func ResampleStream(activity *Activity, t StreamType, times []time.Time) []*float64 {
+	return Resample(activity.Samples(t), times, DefaultInterpolation(t))
+}
+
+// CumulativeDistance returns the distance covered from the first sample to each time,
+// samples in progress are counted in proportion of their elapsed part. Times must be
+// ordered.
+func CumulativeDistance(samples []Sample, times []time.Time) []float64 {
+	distances := make([]float64, len(times))
+	var total float64
+	ended := 0
+	for i, t := range times {
+		for ; ended < len(samples) && !samples[ended].End.After(t); ended++ {
+			total += samples[ended].Value
+		}
+		distance := total
+		for _, sample := range samples[ended:] {
+			if !sample.Start.Before(t) {
+				break
+			}
+			distance += sample.Value * float64(t.Sub(sample.Start)) / float64(sample.End.Sub(sample.Start))
+		}
+		distances[i] = distance
+	}
+	return distances
+}
+
+// interpolate returns the value at t between the middles of two samples
+func interpolate(previous, next Sample, t time.Time) float64 {
+	from := middle(previous)
+	to := middle(next)
+	if !t.After(from) {
+		return previous.Value
+	}
+	if !t.Before(to) {
+		return next.Value
+	}
+	ratio := float64(t.Sub(from)) / float64(to.Sub(from))
+	return previous.Value + ratio*(next.Value-previous.Value)
+}
+
+func middle(sample Sample) time.Time {
+	return sample.Start.Add(sample.End.Sub(sample.Start) / 2)
+}
@@ -0,0 +1,98 @@
+package API
+
+import (
+	"testing"
+	"time"
+)
+
+var resampleStart = time.Date(2026, 10, 1, 7, 30, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return resampleStart.Add(time.Duration(seconds) * time.Second)
+}
+
+// resampleSamples are three samples with a gap between 20s and 30s
+func resampleSamples() []Sample {
+	return []Sample{
+		{Start: at(0), End: at(10), Value: 10},
+		{Start: at(10), End: at(20), Value: 20},
+		{Start: at(30), End: at(40), Value: 40},
+	}
+}
+
+func TestResample(t *testing.T) {
+	times := []time.Time{at(-5), at(0), at(5), at(10), at(25), at(27), at(45)}
+	tests := []struct {
+		name          string
+		interpolation Interpolation
+		expected      []*float64
+	}{
+		{"step", InterpolateStep, values(nil, 10, 10, 20, 20, 20, 40)},
+		{"nearest", InterpolateNearest, values(nil, 10, 10, 20, 20, 40, 40)},
+		{"linear", InterpolateLinear, values(nil, 10, 10, 20, 30, 32, 40)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := Resample(resampleSamples(), times, test.interpolation)
+			if len(actual) != len(test.expected) {
+				t.Fatalf("Expected %d values, got %d", len(test.expected), len(actual))
+			}
+			for i := range actual {
+				if !sameValue(actual[i], test.expected[i]) {
+					t.Errorf("At %v: expected %v, got %v", times[i].Sub(resampleStart), show(test.expected[i]), show(actual[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestResampleWithoutSamples(t *testing.T) {
+	for _, value := range Resample(nil, []time.Time{at(0), at(10)}, InterpolateLinear) {
+		if value != nil {
+			t.Errorf("Expected no value, got %v", *value)
+		}
+	}
+}
+
+func TestCumulativeDistance(t *testing.T) {
+	samples := []Sample{
+		{Start: at(0), End: at(10), Value: 100},
+		{Start: at(10), End: at(20), Value: 50},
+	}
+	times := []time.Time{at(0), at(5), at(10), at(15), at(25)}
+	expected := []float64{0, 50, 100, 125, 150}
+
+	actual := CumulativeDistance(samples, times)
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("At %v: expected %v, got %v", times[i].Sub(resampleStart), expected[i], actual[i])
+		}
+	}
+}
+
+// values builds the expected values of a resampling, nil being given as a nil interface
+func values(expected ...interface{}) []*float64 {
+	result := make([]*float64, len(expected))
+	for i, value := range expected {
+		if value != nil {
+			v := float64(value.(int))
+			result[i] = &v
+		}
+	}
+	return result
+}
+
+func sameValue(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a-*b < 1e-9 && *b-*a < 1e-9
+}
+
+func show(value *float64) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
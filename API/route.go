@@ -0,0 +1,59 @@
+package API
+
+import (
+	"time"
+)
+
+// routePoint is a position of the activity with the other streams resampled at its time
+type routePoint struct {
+	time        time.Time
+	latitude    float64
+	longitude   float64
+	elevation   *float64
+	heartRate   *float64
+	cadence     *float64
+	speed       *float64
+	power       *float64
+	temperature *float64
+}
+
+// buildRoute returns the positions of the activity split into segments at its pauses,
+// points recorded while paused are dropped
+func buildRoute(activity *Activity) [][]routePoint {
+	// Every stream is resampled at the time of the positions
+	times := Timeline(activity, StreamLatitude)
+	latitudes := activity.Samples(StreamLatitude)
+	longitudes := ResampleStream(activity, StreamLongitude, times)
+	elevations := ResampleStream(activity, StreamElevation, times)
+	heartRates := ResampleStream(activity, StreamHeartRate, times)
+	cadences := ResampleStream(activity, StreamCadence, times)
+	speeds := ResampleStream(activity, StreamSpeed, times)
+	powers := ResampleStream(activity, StreamPower, times)
+	temperatures := ResampleStream(activity, StreamTemperature, times)
+
+	pauses := ActivityPauses(activity)
+	var segments [][]routePoint
+	var previous time.Time
+	for i, t := range times {
+		if longitudes[i] == nil || isPaused(pauses, t) {
+			continue
+		}
+
+		if len(segments) == 0 || pausedBetween(pauses, previous, t) {
+			segments = append(segments, nil)
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], routePoint{
+			time:        t,
+			latitude:    latitudes[i].Value,
+			longitude:   *longitudes[i],
+			elevation:   elevations[i],
+			heartRate:   heartRates[i],
+			cadence:     cadences[i],
+			speed:       speeds[i],
+			power:       powers[i],
+			temperature: temperatures[i],
+		})
+		previous = t
+	}
+	return segments
+}
@@ -0,0 +1,370 @@
+package API
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Namespaces of the validated documents
+const (
+	gpxNamespace               = "http://www.topografix.com/GPX/1/1"
+	trackPointExtensionV2      = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+	powerExtensionV1           = "http://www.garmin.com/xmlschemas/PowerExtension/v1"
+	tcxNamespace               = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+	activityExtensionNamespace = "http://www.garmin.com/xmlschemas/ActivityExtension/v2"
+)
+
+// element describes an element of a schema: its occurrences, attributes and either
+// the type of its text or the sequence of its children
+type element struct {
+	name      string
+	namespace string
+	min, max  int
+	attrs     []attribute
+	value     func(string) error
+	children  []element
+	// extensions accepts any element, those of a known namespace are validated
+	extensions map[string]element
+	// foreign requires the extension elements to be in another namespace than the document
+	foreign bool
+}
+
+type attribute struct {
+	name     string
+	required bool
+	value    func(string) error
+}
+
+const unbounded = -1
+
+func optional(name string, children ...element) element {
+	return element{name: name, min: 0, max: 1, children: children}
+}
+
+func required(name string, children ...element) element {
+	return element{name: name, min: 1, max: 1, children: children}
+}
+
+func repeated(name string, min int, children ...element) element {
+	return element{name: name, min: min, max: unbounded, children: children}
+}
+
+func (e element) text(value func(string) error) element {
+	e.value = value
+	return e
+}
+
+func (e element) attributes(attrs ...attribute) element {
+	e.attrs = attrs
+	return e
+}
+
+// anyContent is an element whose content is not validated
+func anyContent(name string, min, max int) element {
+	return element{name: name, min: min, max: max, extensions: map[string]element{}}
+}
+
+func (e element) in(namespace string) element {
+	e.namespace = namespace
+	e.children = append([]element{}, e.children...)
+	for i := range e.children {
+		if len(e.children[i].namespace) == 0 {
+			e.children[i] = e.children[i].in(namespace)
+		}
+	}
+	return e
+}
+
+// node is an element being read, only the elements from the root to the current one are
+// kept so that documents of any size are validated in constant memory
+type node struct {
+	name  xml.Name
+	attrs []xml.Attr
+	// text is read until the first child element
+	text strings.Builder
+	// values holds the text of the first child element of each name without children
+	values map[string]string
+	// elements counts the child elements, indexes counts them by name
+	elements int
+	indexes  map[string]int
+}
+
+func (n *node) attr(name string) (string, bool) {
+	for _, attr := range n.attrs {
+		if attr.Name.Local == name && len(attr.Name.Space) == 0 {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// child returns the text of the first child element having the local name, if it has
+// no children
+func (n *node) child(name string) (string, bool) {
+	value, ok := n.values[name]
+	return value, ok
+}
+
+// documentCheck checks rules beyond the schema while a document is validated
+type documentCheck interface {
+	// start is called with the attributes of an element, before its children
+	start(errs *ValidationErrors, n *node, path string)
+	// end is called once the children of the element are read
+	end(errs *ValidationErrors, n *node, path string)
+}
+
+// frame is an element being validated against its schema element, which is nil when the
+// element is not validated
+type frame struct {
+	node   *node
+	path   string
+	schema *element
+	// position and count locate the last child in the sequence of the schema
+	position, count int
+}
+
+// validateDocument reads the document and checks each element against the schema as it
+// is read, malformed XML is returned as an error
+func validateDocument(r io.Reader, schema element, check documentCheck) (ValidationErrors, error) {
+	decoder := xml.NewDecoder(r)
+	var errs ValidationErrors
+	var stack []*frame
+	root := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: t.Attr}
+			var current *frame
+			if len(stack) > 0 {
+				current = stack[len(stack)-1].open(&errs, n)
+			} else if !root {
+				root = true
+				if t.Name.Local != schema.name {
+					errs.add(t.Name.Local, "unexpected root element, expecting %v", schema.name)
+					return errs, nil
+				}
+				current = &frame{node: n, path: schema.name, schema: &schema}
+			} else {
+				current = &frame{node: n, path: t.Name.Local}
+			}
+			current.start(&errs)
+			check.start(&errs, n, current.path)
+			stack = append(stack, current)
+		case xml.EndElement:
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			current.end(&errs)
+			check.end(&errs, current.node, current.path)
+			if len(stack) > 0 && current.node.elements == 0 {
+				parent := stack[len(stack)-1].node
+				if _, ok := parent.values[t.Name.Local]; !ok {
+					parent.values[t.Name.Local] = strings.TrimSpace(current.node.text.String())
+				}
+			}
+		case xml.CharData:
+			if len(stack) > 0 && stack[len(stack)-1].node.elements == 0 {
+				stack[len(stack)-1].node.text.Write(t)
+			}
+		}
+	}
+	if !root {
+		return nil, errors.New("Empty document")
+	}
+	return errs, nil
+}
+
+// open returns the frame of a child element, finding its schema element
+func (f *frame) open(errs *ValidationErrors, child *node) *frame {
+	parent := f.node
+	if parent.values == nil {
+		parent.values = map[string]string{}
+		parent.indexes = map[string]int{}
+	}
+	index := parent.indexes[child.name.Local]
+	parent.indexes[child.name.Local]++
+	parent.elements++
+	if parent.elements == 1 {
+		parent.text.Reset()
+	}
+
+	opened := &frame{node: child, path: fmt.Sprintf("%v/%v[%d]", f.path, child.name.Local, index)}
+	schema := f.schema
+	switch {
+	case schema == nil:
+	case schema.extensions != nil:
+		if extension, ok := schema.extensions[child.name.Space+" "+child.name.Local]; ok {
+			opened.schema = &extension
+		} else if schema.foreign && child.name.Space == schema.namespace {
+			errs.add(opened.path, "extension element without namespace, expecting a prefixed or namespaced element")
+		}
+	case schema.value != nil || len(schema.children) == 0:
+		if parent.elements == 1 {
+			errs.add(f.path, "unexpected element %v in a simple element", child.name.Local)
+		}
+	default:
+		opened.schema = f.next(errs, child.name.Local, opened.path)
+	}
+	return opened
+}
+
+// next moves in the sequence of the schema to the child element, checking the
+// occurrences of the elements it passes
+func (f *frame) next(errs *ValidationErrors, name, path string) *element {
+	sequence := f.schema.children
+	next := f.position
+	for next < len(sequence) && sequence[next].name != name {
+		next++
+	}
+	if next == len(sequence) {
+		if indexOf(sequence, name) >= 0 {
+			errs.add(path, "element out of order")
+		} else {
+			errs.add(path, "unexpected element")
+		}
+		return nil
+	}
+	if next != f.position {
+		checkOccurrences(errs, sequence[f.position], f.count, f.path)
+		for _, skipped := range sequence[f.position+1 : next] {
+			checkOccurrences(errs, skipped, 0, f.path)
+		}
+		f.position = next
+		f.count = 0
+	}
+
+	f.count++
+	if max := sequence[f.position].max; max != unbounded && f.count > max {
+		errs.add(path, "element occurs more than %d times", max)
+	}
+	return &sequence[f.position]
+}
+
+// start checks the namespace and the attributes of the element
+func (f *frame) start(errs *ValidationErrors) {
+	schema := f.schema
+	if schema == nil {
+		return
+	}
+	if f.node.name.Space != schema.namespace {
+		errs.add(f.path, "element in namespace %q, expecting %q", f.node.name.Space, schema.namespace)
+	}
+
+	for _, attr := range schema.attrs {
+		value, ok := f.node.attr(attr.name)
+		if !ok {
+			if attr.required {
+				errs.add(f.path, "missing attribute %v", attr.name)
+			}
+			continue
+		}
+		if attr.value != nil {
+			if err := attr.value(value); err != nil {
+				errs.add(f.path+"@"+attr.name, "%v", err)
+			}
+		}
+	}
+}
+
+// end checks the text of a simple element, or the elements missing at the end of the sequence
+func (f *frame) end(errs *ValidationErrors) {
+	schema := f.schema
+	switch {
+	case schema == nil || schema.extensions != nil:
+	case schema.value != nil || len(schema.children) == 0:
+		if schema.value != nil && f.node.elements == 0 {
+			if err := schema.value(strings.TrimSpace(f.node.text.String())); err != nil {
+				errs.add(f.path, "%v", err)
+			}
+		}
+	case f.position < len(schema.children):
+		checkOccurrences(errs, schema.children[f.position], f.count, f.path)
+		for _, remaining := range schema.children[f.position+1:] {
+			checkOccurrences(errs, remaining, 0, f.path)
+		}
+	}
+}
+
+func checkOccurrences(errs *ValidationErrors, schema element, count int, path string) {
+	if count < schema.min {
+		errs.add(path, "missing element %v", schema.name)
+	}
+}
+
+func indexOf(sequence []element, name string) int {
+	for i, e := range sequence {
+		if e.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Simple types
+
+func anyString(string) error {
+	return nil
+}
+
+func dateTime(value string) error {
+	if _, err := parseTime(value); err != nil {
+		return errors.Errorf("invalid time %q", value)
+	}
+	return nil
+}
+
+func decimal(min, max float64) func(string) error {
+	return func(value string) error {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) {
+			return errors.Errorf("invalid number %q", value)
+		}
+		if number < min || number > max {
+			return errors.Errorf("%v out of range [%v, %v]", value, min, max)
+		}
+		return nil
+	}
+}
+
+func integer(min, max int64) func(string) error {
+	return func(value string) error {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.Errorf("invalid integer %q", value)
+		}
+		if number < min || number > max {
+			return errors.Errorf("%v out of range [%v, %v]", value, min, max)
+		}
+		return nil
+	}
+}
+
+func enumeration(values ...string) func(string) error {
+	return func(value string) error {
+		if !Contains(values, value) {
+			return errors.Errorf("%q is not one of %v", value, strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+var (
+	anyDecimal     = decimal(math.Inf(-1), math.Inf(1))
+	positiveNumber = decimal(0, math.Inf(1))
+	unsignedShort  = integer(0, 65535)
+	unsignedInt    = integer(0, 4294967295)
+	heartRate      = integer(1, 255)
+	cadence        = integer(0, 254)
+)
@@ -0,0 +1,198 @@
+package API
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const DefaultStatePath = "./runsync_state.json"
+
+// State is the local ledger of synchronized activities, persisted as JSON
+type State struct {
+	path string
+
+	Activities map[string]*StateEntry `json:"activities"`
+	Cursors    map[string]*Cursor     `json:"cursors"`
+}
+
+// Cursor is the high-water mark of a source: the most recent activity synchronized without gap
+type Cursor struct {
+	AfterTime time.Time `json:"after_time"`
+	AfterID   string    `json:"after_id"`
+}
+
+// StateEntry records what has been done for an activity of a source
+type StateEntry struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	StartTime  time.Time `json:"start_time"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Path       string    `json:"path,omitempty"`
+	FileHash   string    `json:"file_hash,omitempty"`
+	UploadID   string    `json:"upload_id,omitempty"`
+	ActivityID string    `json:"activity_id,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// LoadState reads the ledger stored at path, an empty ledger is returned if the file does not exist yet
+func LoadState(path string) (*State, error) {
+	state := &State{
+		path:       path,
+		Activities: map[string]*StateEntry{},
+		Cursors:    map[string]*Cursor{},
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to read state file [%v]", path)
+	}
+
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, errors.WithMessagef(err, "Invalid state file [%v]", path)
+	}
+	if state.Activities == nil {
+		state.Activities = map[string]*StateEntry{}
+	}
+	if state.Cursors == nil {
+		state.Cursors = map[string]*Cursor{}
+	}
+	return state, nil
+}
+
+// Save writes the ledger back to its file, going through a temporary file so
+// that an interrupted run never leaves a truncated ledger behind
+func (s *State) Save() error {
+	content, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.WithMessage(err, "Fail to create temporary state file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.WithMessage(err, "Fail to write state file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.WithMessage(err, "Fail to write state file")
+	}
+	return errors.WithMessage(os.Rename(tmp.Name(), s.path), "Fail to write state file")
+}
+
+// Entry returns the entry of an activity, creating it when it does not exist
+func (s *State) Entry(source, id string) *StateEntry {
+	key := stateKey(source, id)
+	entry, ok := s.Activities[key]
+	if !ok {
+		entry = &StateEntry{
+			ID:     id,
+			Source: source,
+		}
+		s.Activities[key] = entry
+	}
+	return entry
+}
+
+// Lookup returns the entry of an activity, or nil if it has never been synchronized
+func (s *State) Lookup(source, id string) *StateEntry {
+	return s.Activities[stateKey(source, id)]
+}
+
+// Entries returns every entry, sorted by source then identifier
+func (s *State) Entries() []*StateEntry {
+	entries := make([]*StateEntry, 0, len(s.Activities))
+	for _, entry := range s.Activities {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Source != entries[j].Source {
+			return entries[i].Source < entries[j].Source
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// NeedsFetch reports whether the activity is new or has been modified on the source since its last upload
+func (s *State) NeedsFetch(source string, ref ActivityRef) bool {
+	entry := s.Lookup(source, ref.ID)
+	if entry == nil || !entry.Uploaded() {
+		return true
+	}
+	return ref.UpdatedAt.After(entry.UpdatedAt)
+}
+
+// NeedsUpload reports whether the generated file differs from the one already uploaded
+func (s *State) NeedsUpload(source, id, hash string) bool {
+	entry := s.Lookup(source, id)
+	if entry == nil || !entry.Uploaded() {
+		return true
+	}
+	return entry.FileHash != hash
+}
+
+// Cursor returns the high-water mark of a source, a zero cursor means nothing has been synchronized yet
+func (s *State) Cursor(source string) Cursor {
+	if cursor, ok := s.Cursors[source]; ok {
+		return *cursor
+	}
+	return Cursor{}
+}
+
+// Advance moves the high-water mark of a source to the given activity, it never moves backward
+func (s *State) Advance(source string, ref ActivityRef) {
+	cursor, ok := s.Cursors[source]
+	if !ok {
+		cursor = &Cursor{}
+		s.Cursors[source] = cursor
+	}
+	if ref.StartTime.After(cursor.AfterTime) {
+		cursor.AfterTime = ref.StartTime
+		cursor.AfterID = ref.ID
+	}
+}
+
+func (e *StateEntry) Uploaded() bool {
+	return !e.UploadedAt.IsZero()
+}
+
+func stateKey(source, id string) string {
+	return source + "/" + id
+}
+
+// FileHash returns the hex encoded SHA-256 of a file content
+func FileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ContentHash returns the hex encoded SHA-256 of content, equal to FileHash once written to a file
+func ContentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
@@ -25,7 +25,8 @@ func GetBearer(ctx context.Context, clientID, clientSecret, refreshToken string)
 	body.Set("grant_type", "refresh_token")
 	body.Set("refresh_token", refreshToken)
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		"https://www.strava.com/api/v3/oauth/token",
 		strings.NewReader(body.Encode()))
@@ -36,14 +37,15 @@ func GetBearer(ctx context.Context, clientID, clientSecret, refreshToken string)
 	header := request.Header
 	header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	response, err := API.GetClient().Do(request)
+	// Refreshing the token twice is harmless
+	response, err := API.Do(API.Idempotent(request))
 	if err != nil {
 		return nil, errors.WithMessage(err, "Failed to connect to Strava API")
 	}
 
 	defer response.Body.Close()
 	if response.StatusCode != http.StatusOK {
-		return nil, errors.WithMessage(errors.New(response.Status), "Failed to login")
+		return nil, errors.WithMessage(decodeError(response), "Failed to login")
 	}
 
 	var data loginResponse
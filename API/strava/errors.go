@@ -0,0 +1,92 @@
+package strava
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runsync/API"
+	"strings"
+)
+
+// UnexpectedError is the error body returned by Strava with a non successful status,
+// it matches API.ErrUnauthorized, API.ErrRateLimited or API.ErrRejected with errors.Is
+type UnexpectedError struct {
+	StatusCode int     `json:"-"`
+	Errors     []Error `json:"errors"`
+	Message    string  `json:"message"`
+}
+
+type Error struct {
+	Code     string `json:"code"`
+	Field    string `json:"field"`
+	Resource string `json:"resource"`
+}
+
+func (e *UnexpectedError) Error() string {
+	details := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		details = append(details, fmt.Sprintf("%v.%v %v", err.Resource, err.Field, err.Code))
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("%v (%d)", e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("%v (%d): %v", e.Message, e.StatusCode, strings.Join(details, ", "))
+}
+
+func (e *UnexpectedError) Is(target error) bool {
+	switch target {
+	case API.ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden || e.invalidCredentials()
+	case API.ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case API.ErrRejected:
+		return (e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity) && !e.invalidCredentials()
+	}
+	return false
+}
+
+// invalidCredentials reports whether the request was rejected because of the application
+// credentials or the refresh token, which Strava answers with a bad request
+func (e *UnexpectedError) invalidCredentials() bool {
+	for _, err := range e.Errors {
+		switch err.Resource {
+		case "Application", "RefreshToken", "AuthorizationCode", "Athlete":
+			return true
+		}
+	}
+	return false
+}
+
+// UploadError is returned when Strava failed to process an uploaded file, it matches
+// API.ErrDuplicate when the activity already exists and API.ErrRejected otherwise
+type UploadError struct {
+	Upload Upload
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("Upload [%v] failed: %v", e.Upload.ID, e.Upload.Error)
+}
+
+func (e *UploadError) Is(target error) bool {
+	switch target {
+	case API.ErrDuplicate:
+		return e.Upload.DuplicateOf() != 0
+	case API.ErrRejected:
+		return e.Upload.DuplicateOf() == 0
+	}
+	return false
+}
+
+// decodeError builds the error of a non successful response from its body
+func decodeError(response *http.Response) error {
+	data := &UnexpectedError{
+		StatusCode: response.StatusCode,
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil || json.Unmarshal(body, data) != nil || len(data.Message) == 0 {
+		data.Message = response.Status
+	}
+	return data
+}
@@ -0,0 +1,248 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"runsync/API"
+	"strings"
+)
+
+const (
+	activityEndpoint = "activities/%d"
+
+	defaultRulesPath = "strava_rules.json"
+)
+
+// clockPattern matches a time of day written HH:MM, so that times compare as strings
+var clockPattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// UpdatableActivity holds the metadata set on an activity after its upload, only the
+// fields which are not nil are updated
+type UpdatableActivity struct {
+	Name         *string `json:"name,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	SportType    *string `json:"sport_type,omitempty"`
+	GearID       *string `json:"gear_id,omitempty"`
+	Commute      *bool   `json:"commute,omitempty"`
+	Trainer      *bool   `json:"trainer,omitempty"`
+	Visibility   *string `json:"visibility,omitempty"`
+	HideFromHome *bool   `json:"hide_from_home,omitempty"`
+}
+
+// Rule sets metadata on the activities matching all of its conditions
+type Rule struct {
+	Match Match             `json:"match"`
+	Set   UpdatableActivity `json:"set"`
+}
+
+// Match lists the conditions of a rule, an empty condition matches every activity.
+// Days and hours are evaluated in the local time zone, hours are written HH:MM.
+type Match struct {
+	Sport       string            `json:"sport,omitempty"`
+	Weekdays    []string          `json:"weekdays,omitempty"`
+	StartAfter  string            `json:"start_after,omitempty"`
+	StartBefore string            `json:"start_before,omitempty"`
+	MinDistance float64           `json:"min_distance,omitempty"`
+	MaxDistance float64           `json:"max_distance,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// LoadRules reads the rules from a JSON file, no rule is returned if the file does not exist
+func LoadRules(path string) ([]Rule, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Fail to read rules file [%v]", path)
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(content, &rules); err != nil {
+		return nil, errors.WithMessagef(err, "Invalid rules file [%v]", path)
+	}
+	for i, rule := range rules {
+		if err = rule.Match.validate(); err != nil {
+			return nil, errors.WithMessagef(err, "Invalid rule %d of rules file [%v]", i+1, path)
+		}
+	}
+	return rules, nil
+}
+
+// validate checks the values of the conditions which are not checked by their type
+func (m Match) validate() error {
+	for field, value := range map[string]string{"start_after": m.StartAfter, "start_before": m.StartBefore} {
+		if len(value) > 0 && !clockPattern.MatchString(value) {
+			return errors.Errorf("%v [%v] is not a time of day written HH:MM", field, value)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the activity fulfills every condition
+func (m Match) Matches(activity *API.Activity) bool {
+	if activity == nil {
+		return len(m.Sport) == 0 && len(m.Weekdays) == 0 && len(m.StartAfter) == 0 && len(m.StartBefore) == 0 &&
+			m.MinDistance == 0 && m.MaxDistance == 0 && len(m.Tags) == 0
+	}
+
+	start := activity.StartTime.Local()
+	if len(m.Sport) > 0 && !strings.EqualFold(m.Sport, activity.Sport) {
+		return false
+	}
+	if len(m.Weekdays) > 0 && !containsFold(m.Weekdays, start.Weekday().String()) {
+		return false
+	}
+	if len(m.StartAfter) > 0 && start.Format("15:04") < m.StartAfter {
+		return false
+	}
+	if len(m.StartBefore) > 0 && start.Format("15:04") >= m.StartBefore {
+		return false
+	}
+	if m.MinDistance > 0 && activity.Distance() < m.MinDistance {
+		return false
+	}
+	if m.MaxDistance > 0 && activity.Distance() > m.MaxDistance {
+		return false
+	}
+	for key, value := range m.Tags {
+		if activity.Tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Metadata returns the metadata of an activity: its name and a description naming the
+// application it was recorded with, overridden by every matching rule in order. Names and
+// descriptions of rules are naming templates, see API.NameData for the available fields,
+// they are left out when the activity is unknown.
+func Metadata(rules []Rule, activity *API.Activity) (UpdatableActivity, error) {
+	metadata := UpdatableActivity{}
+	if activity != nil {
+		if len(activity.Name) > 0 {
+			name := activity.Name
+			metadata.Name = &name
+		}
+		if sourceName := API.SourceName(activity.Source); len(sourceName) > 0 {
+			description := "Uploaded from " + sourceName
+			metadata.Description = &description
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.Match.Matches(activity) {
+			metadata.merge(rule.Set)
+		}
+	}
+
+	for _, text := range []**string{&metadata.Name, &metadata.Description} {
+		if *text == nil {
+			continue
+		}
+		if activity == nil && strings.Contains(**text, "{{") {
+			*text = nil
+			continue
+		}
+		rendered, err := API.RenderTemplate(**text, activity)
+		if err != nil {
+			return metadata, err
+		}
+		*text = &rendered
+	}
+	return metadata, nil
+}
+
+// merge sets the fields of other which are not nil, copying their values so that the
+// metadata never shares them with a rule
+func (u *UpdatableActivity) merge(other UpdatableActivity) {
+	if other.Name != nil {
+		u.Name = copyString(other.Name)
+	}
+	if other.Description != nil {
+		u.Description = copyString(other.Description)
+	}
+	if other.SportType != nil {
+		u.SportType = copyString(other.SportType)
+	}
+	if other.GearID != nil {
+		u.GearID = copyString(other.GearID)
+	}
+	if other.Commute != nil {
+		u.Commute = copyBool(other.Commute)
+	}
+	if other.Trainer != nil {
+		u.Trainer = copyBool(other.Trainer)
+	}
+	if other.Visibility != nil {
+		u.Visibility = copyString(other.Visibility)
+	}
+	if other.HideFromHome != nil {
+		u.HideFromHome = copyBool(other.HideFromHome)
+	}
+}
+
+func copyString(value *string) *string {
+	copied := *value
+	return &copied
+}
+
+func copyBool(value *bool) *bool {
+	copied := *value
+	return &copied
+}
+
+// UpdateActivity sets the metadata of an existing activity
+func UpdateActivity(ctx context.Context, accessToken string, id int64, update UpdatableActivity) error {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	b, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, baseURL+fmt.Sprintf(activityEndpoint, id), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	header := request.Header
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := API.Do(request)
+	if err != nil {
+		return errors.WithMessage(err, "Failed to connect to Strava API")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.WithMessagef(decodeError(response), "Failed to update activity [%v]", id)
+	}
+	return nil
+}
+
+func containsFold(values []string, str string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// rulesPath returns the rules file configured in the environment
+func rulesPath() string {
+	if path := os.Getenv("STRAVA_RULES_FILE"); len(path) > 0 {
+		return path
+	}
+	return defaultRulesPath
+}
@@ -0,0 +1,72 @@
+package strava
+
+import (
+	"runsync/API"
+	"testing"
+	"time"
+)
+
+func TestMetadataRendersRulesPerActivity(t *testing.T) {
+	template := "{{.Weekday}} {{.SportName}}"
+	rules := []Rule{{Set: UpdatableActivity{Name: &template}}}
+
+	monday := &API.Activity{ID: "1", Sport: API.SportRunning, StartTime: time.Date(2026, 10, 5, 8, 0, 0, 0, time.Local)}
+	wednesday := &API.Activity{ID: "2", Sport: API.SportRunning, StartTime: time.Date(2026, 10, 7, 8, 0, 0, 0, time.Local)}
+
+	for _, test := range []struct {
+		activity *API.Activity
+		name     string
+	}{
+		{monday, "Monday run"},
+		{wednesday, "Wednesday run"},
+	} {
+		metadata, err := Metadata(rules, test.activity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := value(metadata.Name); got != test.name {
+			t.Errorf("Name of activity [%v] = %q, want %q", test.activity.ID, got, test.name)
+		}
+	}
+
+	if *rules[0].Set.Name != template {
+		t.Errorf("Rule name changed to %q", *rules[0].Set.Name)
+	}
+}
+
+func TestMetadataLeavesTemplatesOutForUnknownActivity(t *testing.T) {
+	name := "{{.TimeOfDay}} run"
+	description := "Synchronized"
+	rules := []Rule{{Set: UpdatableActivity{Name: &name, Description: &description}}}
+
+	metadata, err := Metadata(rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != nil {
+		t.Errorf("Name = %q, want none", *metadata.Name)
+	}
+	if metadata.Description == nil || *metadata.Description != description {
+		t.Errorf("Description = %v, want %q", metadata.Description, description)
+	}
+}
+
+func TestMetadataDescribesSource(t *testing.T) {
+	for source, want := range map[string]string{"nike": "Uploaded from NRC", "file": ""} {
+		metadata, err := Metadata(nil, &API.Activity{Source: source, Sport: API.SportRunning})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := value(metadata.Description); got != want {
+			t.Errorf("Description of a %v activity = %q, want %q", source, got, want)
+		}
+	}
+}
+
+// value returns the string pointed to, or an empty string for nil
+func value(text *string) string {
+	if text == nil {
+		return ""
+	}
+	return *text
+}
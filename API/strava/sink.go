@@ -0,0 +1,119 @@
+package strava
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"runsync/API"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	API.RegisterSink("strava", NewSink)
+}
+
+// sink uploads activity files to Strava
+type sink struct {
+	clientID     string
+	clientSecret string
+	refreshToken string
+	rules        []Rule
+
+	// mutex guards the access token, activities are pushed concurrently
+	mutex       sync.Mutex
+	accessToken *string
+}
+
+func NewSink() (API.Sink, error) {
+	clientID := os.Getenv("STRAVA_CLIENT_ID")
+	clientSecret := os.Getenv("STRAVA_CLIENT_SECRET")
+	refreshToken := os.Getenv("STRAVA_REFRESH_TOKEN")
+
+	if len(clientID) == 0 || len(clientSecret) == 0 || len(refreshToken) == 0 {
+		return nil, errors.New("Please set your Strava application parameters in .env")
+	}
+
+	rules, err := LoadRules(rulesPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return &sink{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		refreshToken: refreshToken,
+		rules:        rules,
+	}, nil
+}
+
+func (s *sink) bearer(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.accessToken == nil {
+		accessToken, err := GetBearer(ctx, s.clientID, s.clientSecret, s.refreshToken)
+		if err != nil {
+			return "", errors.WithMessage(err, "Fail to get bearer from Strava")
+		}
+		s.accessToken = accessToken
+	}
+	return *s.accessToken, nil
+}
+
+// invalidate forgets the access token so that the next call to bearer refreshes it, unless
+// another push already did
+func (s *sink) invalidate(accessToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.accessToken != nil && *s.accessToken == accessToken {
+		s.accessToken = nil
+	}
+}
+
+func (s *sink) Push(ctx context.Context, path string, activity *API.Activity) (*API.PushResult, error) {
+	log.Infof("[strava] Import file %v", path)
+	accessToken, err := s.bearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := upload(ctx, accessToken, path)
+	if errors.Is(err, API.ErrUnauthorized) {
+		// The token may have expired, it is refreshed and the upload tried once more, a
+		// second refusal stops the batch
+		log.WithError(err).Warnf("[strava] Authorization refused for [%v], refreshing the token", path)
+		s.invalidate(accessToken)
+		if accessToken, err = s.bearer(ctx); err != nil {
+			return nil, err
+		}
+		result, err = upload(ctx, accessToken, path)
+	}
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) && errors.Is(err, API.ErrDuplicate) {
+		log.Warnf("[strava] File [%v] is a duplicate of activity [%v]", path, uploadErr.Upload.DuplicateOf())
+		return &API.PushResult{
+			UploadID:   strconv.FormatInt(uploadErr.Upload.ID, 10),
+			ActivityID: strconv.FormatInt(uploadErr.Upload.DuplicateOf(), 10),
+			Duplicate:  true,
+		}, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Upload failed for [%v]", path)
+	}
+
+	// The activity is uploaded at this point, a failed update only loses its metadata
+	metadata, err := Metadata(s.rules, activity)
+	if err == nil {
+		err = UpdateActivity(ctx, accessToken, result.ActivityID, metadata)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("[strava] Fail to update metadata of activity [%v]", result.ActivityID)
+	}
+
+	return &API.PushResult{
+		UploadID:   strconv.FormatInt(result.ID, 10),
+		ActivityID: strconv.FormatInt(result.ActivityID, 10),
+	}, nil
+}
@@ -1,110 +1,240 @@
 package strava
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runsync/API"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
+	baseURL = "https://www.strava.com/api/v3/"
+
+	uploadsEndpoint = "uploads"
+	uploadEndpoint  = "uploads/%d"
+
 	httpTimeout = 30 * time.Second
+	// uploadRate is the slowest upload accepted, in bytes of the file per second, the
+	// deadline of an upload grows with the size of its file
+	uploadRate = 32 << 10
+
+	// Strava processes uploads asynchronously, they are polled until ready or failed
+	pollInterval = 2 * time.Second
+	pollTimeout  = 2 * time.Minute
 )
 
-func ImportDataFromFiles(path string) {
-	log.Infof("[strava] Import file %v", path)
-	stravaClientId := os.Getenv("STRAVA_CLIENT_ID")
-	stravaClientSecret := os.Getenv("STRAVA_CLIENT_SECRET")
-	stravaRefreshToken := os.Getenv("STRAVA_REFRESH_TOKEN")
+var duplicatePattern = regexp.MustCompile(`duplicate of .*?(?:activities/|activity )(\d+)`)
 
-	if len(stravaClientId) == 0 || len(stravaClientSecret) == 0 || len(stravaRefreshToken) == 0 {
-		log.Error("[strava] Please set your Strava application parameters in .env")
-	}
+// Upload is the processing status of an uploaded file
+type Upload struct {
+	ID         int64  `json:"id"`
+	ExternalID string `json:"external_id"`
+	Error      string `json:"error"`
+	Status     string `json:"status"`
+	ActivityID int64  `json:"activity_id"`
+}
 
-	ctx := context.Background()
-	accessToken, err := GetBearer(ctx, stravaClientId, stravaClientSecret, stravaRefreshToken)
-	if err != nil {
-		log.WithError(err).Error("[strava] Fail to get Bearer")
-	}
+// Ready reports whether Strava created the activity
+func (u *Upload) Ready() bool {
+	return u.ActivityID != 0
+}
 
-	err = upload(*accessToken, path)
-	if err != nil {
-		log.WithError(err).Error("[strava] Upload failed")
-	}
+// Failed reports whether Strava rejected the file
+func (u *Upload) Failed() bool {
+	return len(u.Error) > 0
 }
 
-func upload(accessToken, path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+// DuplicateOf returns the identifier of the existing activity when the upload was
+// rejected as a duplicate, or 0 otherwise
+func (u *Upload) DuplicateOf() int64 {
+	matches := duplicatePattern.FindStringSubmatch(u.Error)
+	if matches == nil {
+		return 0
 	}
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, _ := writer.CreateFormFile("file", path)
+	id, _ := strconv.ParseInt(matches[1], 10, 64)
+	return id
+}
 
-	gzBuffer := &bytes.Buffer{}
-	gzWriter := gzip.NewWriter(gzBuffer)
+// upload sends the file to Strava and waits for it to be processed
+func upload(ctx context.Context, accessToken, path string) (*Upload, error) {
+	// Strava rejects non conforming files after processing them, check them beforehand
+	if err := API.ValidateFile(path); err != nil {
+		return nil, errors.WithMessagef(err, "Invalid file [%v]", path)
+	}
 
-	_, err = io.Copy(gzWriter, file)
-	gzWriter.Close()
+	created, err := createUpload(ctx, accessToken, path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	log.Infof("[strava] File [%v] uploaded with id [%v], waiting for processing", path, created.ID)
 
-	io.Copy(part, gzBuffer)
+	return pollUpload(ctx, accessToken, created)
+}
 
-	writer.WriteField("description", "Uploaded from NRC")
+func createUpload(ctx context.Context, accessToken, path string) (*Upload, error) {
+	var dataType string
 	if strings.HasSuffix(path, ".gpx") {
-		writer.WriteField("data_type", "gpx.gz")
+		dataType = "gpx.gz"
 	} else if strings.HasSuffix(path, ".tcx") {
-		writer.WriteField("data_type", "tcx.gz")
+		dataType = "tcx.gz"
+	} else if strings.HasSuffix(path, ".fit") {
+		dataType = "fit.gz"
 	} else {
-		log.Fatal("[strava] Unrecognized file type [%v]", path)
+		return nil, errors.Errorf("Unrecognized file type [%v]", path)
 	}
 
-	writer.Close()
+	// The file is gzipped into the multipart body while the request is sent, it is read
+	// again when the request is retried
+	form := multipart.NewWriter(ioutil.Discard)
+	newBody := func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		body, pipe := io.Pipe()
+		writer := multipart.NewWriter(pipe)
+		if err = writer.SetBoundary(form.Boundary()); err != nil {
+			file.Close()
+			return nil, err
+		}
+		go func() {
+			defer file.Close()
+			pipe.CloseWithError(writeUploadBody(writer, file, path, dataType))
+		}()
+		return body, nil
+	}
 
-	req, err := http.NewRequest("POST", "https://www.strava.com/api/v3/uploads", body)
-	req.Header.Add("Content-Type", "multipart/form-data; boundary="+writer.Boundary())
-	req.Header.Add("Authorization", "Bearer "+accessToken)
+	body, err := newBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
 
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout(path))
+	defer cancel()
 
-	resp, err := API.GetClient().Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+uploadsEndpoint, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	req.GetBody = newBody
+	req.Header.Add("Content-Type", form.FormDataContentType())
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := API.Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to connect to Strava API")
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		fmt.Println("Error")
+		return nil, errors.WithMessage(decodeError(resp), "Failed to upload file")
 	}
 
-	_, err = ioutil.ReadAll(resp.Body)
+	var data Upload
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, errors.WithMessage(err, "Invalid upload response")
+	}
+	return &data, nil
+}
 
-	var bodyContent []byte
-	resp.Body.Read(bodyContent)
+// uploadTimeout returns the time given to send a file and get the response, the time of
+// any request plus the time to send the file at uploadRate
+func uploadTimeout(path string) time.Duration {
+	timeout := httpTimeout
+	if info, err := os.Stat(path); err == nil {
+		timeout += time.Duration(info.Size()) * time.Second / uploadRate
+	}
+	return timeout
+}
 
-	defer resp.Body.Close()
+// writeUploadBody writes the upload form, with the file gzipped on the fly
+func writeUploadBody(writer *multipart.Writer, file io.Reader, path, dataType string) error {
+	if err := writer.WriteField("data_type", dataType); err != nil {
+		return err
+	}
+	if id := API.ActivityIDFromPath(path); len(id) > 0 {
+		if err := writer.WriteField("external_id", id); err != nil {
+			return err
+		}
+	}
 
-	log.Infof("[strava] Import done")
-	return nil
+	part, err := writer.CreateFormFile("file", filepath.Base(path)+".gz")
+	if err != nil {
+		return err
+	}
+	gzWriter := gzip.NewWriter(part)
+	if _, err = io.Copy(gzWriter, file); err != nil {
+		return err
+	}
+	if err = gzWriter.Close(); err != nil {
+		return err
+	}
+	return writer.Close()
 }
 
-type UnexpectedError struct {
-	Errors  []Error `json:"errors"`
-	Message string  `json:"message"`
+// pollUpload waits until Strava either created the activity or rejected the upload
+func pollUpload(ctx context.Context, accessToken string, current *Upload) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	for !current.Ready() && !current.Failed() {
+		log.Debugf("[strava] Upload [%v]: %v", current.ID, current.Status)
+
+		select {
+		case <-ctx.Done():
+			return current, errors.WithMessagef(ctx.Err(), "Upload [%v] still processing", current.ID)
+		case <-time.After(pollInterval):
+		}
+
+		next, err := getUpload(ctx, accessToken, current.ID)
+		if err != nil {
+			return current, err
+		}
+		current = next
+	}
+
+	if current.Failed() {
+		return current, &UploadError{Upload: *current}
+	}
+
+	log.Infof("[strava] Upload [%v] processed as activity [%v]", current.ID, current.ActivityID)
+	return current, nil
 }
 
-type Error struct {
-	Code     string `json:"code"`
-	Field    string `json:"field"`
-	Resource string `json:"resource"`
+func getUpload(ctx context.Context, accessToken string, id int64) (*Upload, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+fmt.Sprintf(uploadEndpoint, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := API.Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to connect to Strava API")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessagef(decodeError(resp), "Failed to get upload [%v]", id)
+	}
+
+	var data Upload
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, errors.WithMessage(err, "Invalid upload response")
+	}
+	return &data, nil
 }
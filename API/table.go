@@ -0,0 +1,239 @@
+package API
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// SummaryFileName is the name, without extension, of the table summarizing the activities
+// written as CSV or JSON lines
+const SummaryFileName = "summary"
+
+var (
+	sampleColumns  = []string{"time", "latitude", "longitude", "elevation", "heart_rate", "speed", "distance"}
+	summaryColumns = []string{"id", "source", "sport", "name", "start_time", "distance", "duration", "calories", "avg_heart_rate", "max_heart_rate", "avg_speed"}
+)
+
+// SampleRow holds the streams of an activity resampled at a time, missing values are nil
+type SampleRow struct {
+	Time      time.Time `json:"time"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Elevation *float64  `json:"elevation"`
+	HeartRate *float64  `json:"heart_rate"`
+	// Speed in meters per second
+	Speed *float64 `json:"speed"`
+	// Distance in meters covered since the start
+	Distance *float64 `json:"distance"`
+}
+
+// SummaryRow holds the totals of an activity, from its summaries when available and its
+// streams otherwise
+type SummaryRow struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	Sport     string    `json:"sport"`
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"start_time"`
+	// Distance in meters
+	Distance float64 `json:"distance"`
+	// Duration in seconds
+	Duration     float64  `json:"duration"`
+	Calories     *float64 `json:"calories"`
+	AvgHeartRate *float64 `json:"avg_heart_rate"`
+	MaxHeartRate *float64 `json:"max_heart_rate"`
+	// AvgSpeed in meters per second
+	AvgSpeed *float64 `json:"avg_speed"`
+}
+
+// tableRow is a row of a CSV or JSON lines table, encoded as JSON by its fields
+type tableRow interface {
+	record() []string
+}
+
+// BuildSampleRows resamples the streams of the activity at the time of its positions, or of
+// its speed, distance or heart rate samples when it has no GPS track
+func BuildSampleRows(activity *Activity) []SampleRow {
+	times := Timeline(activity, StreamLatitude, StreamSpeed, StreamDistance, StreamHeartRate)
+	latitudes := ResampleStream(activity, StreamLatitude, times)
+	longitudes := ResampleStream(activity, StreamLongitude, times)
+	elevations := ResampleStream(activity, StreamElevation, times)
+	heartRates := ResampleStream(activity, StreamHeartRate, times)
+	speeds := ResampleStream(activity, StreamSpeed, times)
+
+	var distances []float64
+	if activity.HasStream(StreamDistance) {
+		distances = CumulativeDistance(activity.Samples(StreamDistance), times)
+	}
+
+	rows := make([]SampleRow, len(times))
+	for i, t := range times {
+		rows[i] = SampleRow{
+			Time:      t.UTC(),
+			Latitude:  latitudes[i],
+			Longitude: longitudes[i],
+			Elevation: elevations[i],
+			HeartRate: heartRates[i],
+			Speed:     speeds[i],
+		}
+		if distances != nil {
+			rows[i].Distance = &distances[i]
+		}
+	}
+	return rows
+}
+
+// BuildSummaryRow returns the totals of the activity
+func BuildSummaryRow(activity *Activity) SummaryRow {
+	row := SummaryRow{
+		ID:        activity.ID,
+		Source:    activity.Source,
+		Sport:     activity.Sport,
+		Name:      activity.Name,
+		StartTime: activity.StartTime.UTC(),
+		Distance:  activity.Distance(),
+		Duration:  activity.Duration.Seconds(),
+	}
+
+	if summary := activity.SummaryOf("calories", SummaryTotal); summary != nil {
+		row.Calories = &summary.Value
+	}
+
+	heartRates := activity.Samples(StreamHeartRate)
+	if summary := activity.SummaryOf("heart_rate", SummaryMean); summary != nil {
+		row.AvgHeartRate = &summary.Value
+	} else if len(heartRates) > 0 {
+		var sum float64
+		for _, sample := range heartRates {
+			sum += sample.Value
+		}
+		mean := sum / float64(len(heartRates))
+		row.AvgHeartRate = &mean
+	}
+	if summary := activity.SummaryOf("heart_rate", SummaryMax); summary != nil {
+		row.MaxHeartRate = &summary.Value
+	} else if len(heartRates) > 0 {
+		max := math.Inf(-1)
+		for _, sample := range heartRates {
+			max = math.Max(max, sample.Value)
+		}
+		row.MaxHeartRate = &max
+	}
+
+	if summary := activity.SummaryOf("speed", SummaryMean); summary != nil {
+		row.AvgSpeed = &summary.Value
+	} else if row.Duration > 0 {
+		speed := row.Distance / row.Duration
+		row.AvgSpeed = &speed
+	}
+	return row
+}
+
+// EncodeSamples writes the sample rows to w as CSV, with a header, or JSON lines
+func EncodeSamples(w io.Writer, format string, rows []SampleRow) error {
+	table := make([]tableRow, len(rows))
+	for i := range rows {
+		table[i] = rows[i]
+	}
+	return encodeTable(w, format, sampleColumns, table)
+}
+
+// EncodeSummaries writes the summary rows to w as CSV, with a header, or JSON lines
+func EncodeSummaries(w io.Writer, format string, rows []SummaryRow) error {
+	table := make([]tableRow, len(rows))
+	for i := range rows {
+		table[i] = rows[i]
+	}
+	return encodeTable(w, format, summaryColumns, table)
+}
+
+// WriteSummariesToFile writes a summary row per activity to the summary file of the given
+// format under directory and returns the file path
+func WriteSummariesToFile(directory, format string, activities []*Activity) (string, error) {
+	rows := make([]SummaryRow, len(activities))
+	for i, activity := range activities {
+		rows[i] = BuildSummaryRow(activity)
+	}
+
+	path := filepath.Join(directory, SummaryFileName+"."+format)
+	err := writeFile(path, func(w io.Writer) error {
+		return EncodeSummaries(w, format, rows)
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "Fail to write summary file [%v]", path)
+	}
+	return path, nil
+}
+
+func encodeTable(w io.Writer, format string, columns []string, rows []tableRow) error {
+	switch format {
+	case FormatCsv:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := writer.Write(row.record()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatJsonl:
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("Unsupported table format [%v]", format)
+	}
+}
+
+func (r SampleRow) record() []string {
+	return []string{
+		formatTime(r.Time),
+		formatValue(r.Latitude),
+		formatValue(r.Longitude),
+		formatValue(r.Elevation),
+		formatValue(r.HeartRate),
+		formatValue(r.Speed),
+		formatValue(r.Distance),
+	}
+}
+
+func (r SummaryRow) record() []string {
+	return []string{
+		r.ID,
+		r.Source,
+		r.Sport,
+		r.Name,
+		formatTime(r.StartTime),
+		formatValue(&r.Distance),
+		formatValue(&r.Duration),
+		formatValue(r.Calories),
+		formatValue(r.AvgHeartRate),
+		formatValue(r.MaxHeartRate),
+		formatValue(r.AvgSpeed),
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatValue formats a value of a CSV record, empty when missing
+func formatValue(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
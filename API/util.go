@@ -8,14 +8,26 @@ import (
 
 var (
 	ErrInvalidLoginResponse = errors.New("Invalid login response from server")
+
+	// Errors returned by providers are matched against these with errors.Is,
+	// so that callers can react without knowing the provider
+	ErrUnauthorized = errors.New("Authorization refused")
+	ErrRateLimited  = errors.New("Rate limit exceeded")
+	ErrRejected     = errors.New("Request rejected")
+	ErrDuplicate    = errors.New("Activity already exists")
 )
 
+// sharedClient is used by every request, so that its transport keeps the connections
+// open between them
+var sharedClient = &http.Client{
+	Transport: &http.Transport{
+		TLSNextProto: make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
+	},
+}
+
+// GetClient returns the HTTP client shared by the providers
 func GetClient() *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			TLSNextProto: make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-		},
-	}
+	return sharedClient
 }
 
 func Contains(array []string, str string) bool {
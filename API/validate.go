@@ -0,0 +1,460 @@
+package API
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError locates a problem in a generated document
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Message)
+}
+
+// ValidationErrors gathers every problem found in a document
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is matches ErrRejected: an invalid document would be rejected by any sink
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrRejected
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (e ValidationErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateActivity builds the document of an activity in the given format and validates it
+func ValidateActivity(format string, activity *Activity) error {
+	switch format {
+	case FormatGpx:
+		return ValidateGpx(BuildGpx(activity))
+	case FormatTcx:
+		return ValidateTcx(BuildTcx(activity))
+	case FormatFit:
+		content, err := MarshalFit(activity)
+		if err != nil {
+			return err
+		}
+		return ValidateFit(content)
+	default:
+		return nil
+	}
+}
+
+func ValidateGpx(gpx *GPX) error {
+	content, err := MarshalGpx(gpx)
+	if err != nil {
+		return err
+	}
+	return ValidateDocument(FormatGpx, bytes.NewReader(content))
+}
+
+func ValidateTcx(tcx *TrainingCenterDatabase) error {
+	content, err := MarshalTcx(tcx)
+	if err != nil {
+		return err
+	}
+	return ValidateDocument(FormatTcx, bytes.NewReader(content))
+}
+
+// ValidateFile validates a GPX, TCX or FIT file according to its extension
+func ValidateFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := FormatFromPath(path)
+	if format == FormatFit {
+		return ValidateFitReader(file)
+	}
+	return ValidateDocument(format, file)
+}
+
+// ValidateDocument checks a GPX 1.1 or TCX v2 document against the structure of its
+// schema (required elements, ordering, value types and ranges) and the rules of an
+// activity upload: at least one point, times not going backward and, for TCX, distances
+// not decreasing
+func ValidateDocument(format string, r io.Reader) error {
+	var schema element
+	var check documentCheck
+	switch format {
+	case FormatGpx:
+		schema, check = gpxSchema, &gpxCheck{}
+	case FormatTcx:
+		schema, check = tcxSchema, &tcxCheck{}
+	default:
+		return errors.Errorf("Unsupported format [%v]", format)
+	}
+
+	errs, err := validateDocument(r, schema, check)
+	if err != nil {
+		return errors.WithMessage(err, "Malformed XML document")
+	}
+	return errs.orNil()
+}
+
+// ValidateFit checks the header, the size and the CRC of a FIT file
+func ValidateFit(content []byte) error {
+	return ValidateFitReader(bytes.NewReader(content))
+}
+
+// ValidateFitReader checks the header, the size and the CRC of a FIT file as it is read
+func ValidateFitReader(r io.Reader) error {
+	var errs ValidationErrors
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		errs.add("header", "not a FIT file")
+		return errs
+	} else if err != nil {
+		return err
+	}
+	if header[0] < 12 || string(header[8:12]) != ".FIT" {
+		errs.add("header", "not a FIT file")
+		return errs
+	}
+
+	headerSize := int(header[0])
+	header = append(header, make([]byte, headerSize-12)...)
+	read, err := io.ReadFull(r, header[12:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if headerSize >= 14 && read >= 2 {
+		if crc := binary.LittleEndian.Uint16(header[12:14]); crc != 0 && crc != fitCrc(0, header[:12]) {
+			errs.add("header", "invalid header CRC")
+		}
+	}
+
+	// The data is read through the CRC, the file must end with the CRC right after it
+	dataSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+	crc := fitCrcWriter(fitCrc(0, header[:12+read]))
+	data, err := io.CopyN(&crc, r, dataSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var trailer [2]byte
+	crcSize, err := io.ReadFull(r, trailer[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	extra, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return err
+	}
+
+	if read < headerSize-12 || data < dataSize || crcSize < 2 || extra > 0 {
+		fileSize := int64(12+read+crcSize) + data + extra
+		errs.add("header", "data size %d does not match the file size %d", dataSize, fileSize)
+		return errs
+	}
+	if binary.LittleEndian.Uint16(trailer[:]) != uint16(crc) {
+		errs.add("crc", "invalid file CRC")
+	}
+	return errs.orNil()
+}
+
+// fitCrcWriter computes the FIT CRC of what is written to it
+type fitCrcWriter uint16
+
+func (w *fitCrcWriter) Write(p []byte) (int, error) {
+	*w = fitCrcWriter(fitCrc(uint16(*w), p))
+	return len(p), nil
+}
+
+// gpxCheck checks that a GPX document has trackpoints in chronological order
+type gpxCheck struct {
+	previous time.Time
+	points   int
+}
+
+func (c *gpxCheck) start(errs *ValidationErrors, n *node, path string) {}
+
+func (c *gpxCheck) end(errs *ValidationErrors, n *node, path string) {
+	switch {
+	case n.name.Local == "trkpt":
+		c.points++
+		value, ok := n.child("time")
+		if !ok {
+			errs.add(path, "missing time")
+			return
+		}
+		c.previous = validateTime(errs, path+"/time", value, c.previous)
+	case path == "gpx" && c.points == 0:
+		errs.add("gpx", "no trackpoint")
+	}
+}
+
+// tcxCheck checks that each activity of a TCX document has trackpoints in chronological
+// order, with distances not decreasing
+type tcxCheck struct {
+	activities int
+
+	// State of the current activity
+	previous         time.Time
+	previousDistance float64
+	points           int
+	distances        int
+	moved            bool
+}
+
+func (c *tcxCheck) start(errs *ValidationErrors, n *node, path string) {
+	switch n.name.Local {
+	case "Activity":
+		*c = tcxCheck{activities: c.activities + 1}
+	case "Lap":
+		if value, ok := n.attr("StartTime"); ok {
+			c.previous = validateTime(errs, path+"@StartTime", value, c.previous)
+		}
+	}
+}
+
+func (c *tcxCheck) end(errs *ValidationErrors, n *node, path string) {
+	switch {
+	case n.name.Local == "Trackpoint":
+		c.points++
+		if value, ok := n.child("Time"); ok {
+			c.previous = validateTime(errs, path+"/Time", value, c.previous)
+		}
+		if value, ok := n.child("DistanceMeters"); ok {
+			distance, err := strconv.ParseFloat(value, 64)
+			if err == nil && distance < c.previousDistance {
+				errs.add(path+"/DistanceMeters", "distance %v is below the previous point", value)
+			}
+			c.previousDistance = distance
+			c.distances++
+			c.moved = c.moved || distance > 0
+		}
+	case n.name.Local == "Activity":
+		if c.points == 0 {
+			errs.add(path, "no trackpoint")
+		}
+		// A distance which never grows is a missing distance written as zero
+		if c.distances > 1 && !c.moved {
+			errs.add(path, "every trackpoint distance is zero")
+		}
+	case path == "TrainingCenterDatabase" && c.activities == 0:
+		errs.add("TrainingCenterDatabase/Activities", "no activity")
+	}
+}
+
+// validateTime checks that value is a timestamp not before previous, and returns it
+func validateTime(errs *ValidationErrors, path, value string, previous time.Time) time.Time {
+	t, err := parseTime(value)
+	if err != nil {
+		errs.add(path, "invalid time %q", value)
+		return previous
+	}
+	if t.Before(previous) {
+		errs.add(path, "time %v is before the previous point", value)
+	}
+	return t
+}
+
+var (
+	gpxLink = repeated("link", 0,
+		optional("text").text(anyString),
+		optional("type").text(anyString),
+	).attributes(attribute{name: "href", required: true})
+
+	gpxExtensions = element{name: "extensions", min: 0, max: 1, foreign: true, extensions: map[string]element{
+		trackPointExtensionV2 + " TrackPointExtension": required("TrackPointExtension",
+			optional("atemp").text(anyDecimal),
+			optional("wtemp").text(anyDecimal),
+			optional("depth").text(positiveNumber),
+			optional("hr").text(heartRate),
+			optional("cad").text(cadence),
+			optional("speed").text(positiveNumber),
+			optional("course").text(decimal(0, 360)),
+			optional("bearing").text(decimal(0, 360)),
+			anyContent("Extensions", 0, 1),
+		).in(trackPointExtensionV2),
+		powerExtensionV1 + " PowerInWatts": required("PowerInWatts").text(unsignedInt).in(powerExtensionV1),
+	}}
+
+	gpxTrackpoint = repeated("trkpt", 0,
+		optional("ele").text(anyDecimal),
+		optional("time").text(dateTime),
+		optional("magvar").text(decimal(0, 360)),
+		optional("geoidheight").text(anyDecimal),
+		optional("name").text(anyString),
+		optional("cmt").text(anyString),
+		optional("desc").text(anyString),
+		optional("src").text(anyString),
+		gpxLink,
+		optional("sym").text(anyString),
+		optional("type").text(anyString),
+		optional("fix").text(enumeration("none", "2d", "3d", "dgps", "pps")),
+		optional("sat").text(integer(0, math.MaxInt32)),
+		optional("hdop").text(anyDecimal),
+		optional("vdop").text(anyDecimal),
+		optional("pdop").text(anyDecimal),
+		optional("ageofdgpsdata").text(anyDecimal),
+		optional("dgpsid").text(integer(0, 1023)),
+		gpxExtensions,
+	).attributes(
+		attribute{name: "lat", required: true, value: decimal(-90, 90)},
+		attribute{name: "lon", required: true, value: decimal(-180, 180)},
+	)
+
+	gpxSchema = required("gpx",
+		optional("metadata",
+			optional("name").text(anyString),
+			optional("desc").text(anyString),
+			anyContent("author", 0, 1),
+			anyContent("copyright", 0, 1),
+			gpxLink,
+			optional("time").text(dateTime),
+			optional("keywords").text(anyString),
+			anyContent("bounds", 0, 1),
+			gpxExtensions,
+		),
+		anyContent("wpt", 0, unbounded),
+		anyContent("rte", 0, unbounded),
+		repeated("trk", 0,
+			optional("name").text(anyString),
+			optional("cmt").text(anyString),
+			optional("desc").text(anyString),
+			optional("src").text(anyString),
+			gpxLink,
+			optional("number").text(integer(0, math.MaxInt32)),
+			optional("type").text(anyString),
+			gpxExtensions,
+			repeated("trkseg", 0,
+				gpxTrackpoint,
+				gpxExtensions,
+			),
+		),
+		gpxExtensions,
+	).attributes(
+		attribute{name: "version", required: true, value: enumeration("1.1")},
+		attribute{name: "creator", required: true},
+	).in(gpxNamespace)
+
+	tcxHeartRate = func(name string) element {
+		return optional(name,
+			required("Value").text(heartRate),
+		)
+	}
+
+	tcxLapExtensions = element{name: "Extensions", min: 0, max: 1, foreign: true, extensions: map[string]element{
+		activityExtensionNamespace + " LX": required("LX",
+			optional("AvgSpeed").text(positiveNumber),
+			optional("MaxBikeCadence").text(cadence),
+			optional("AvgRunCadence").text(cadence),
+			optional("MaxRunCadence").text(cadence),
+			optional("Steps").text(unsignedShort),
+			optional("AvgWatts").text(unsignedShort),
+			optional("MaxWatts").text(unsignedShort),
+			anyContent("Extensions", 0, 1),
+		).in(activityExtensionNamespace),
+	}}
+
+	tcxTrackpointExtensions = element{name: "Extensions", min: 0, max: 1, foreign: true, extensions: map[string]element{
+		activityExtensionNamespace + " TPX": required("TPX",
+			optional("Speed").text(positiveNumber),
+			optional("RunCadence").text(cadence),
+			optional("Watts").text(unsignedShort),
+			anyContent("Extensions", 0, 1),
+		).attributes(
+			attribute{name: "CadenceSensor", value: enumeration("Footpod", "Bike")},
+		).in(activityExtensionNamespace),
+	}}
+
+	tcxSchema = required("TrainingCenterDatabase",
+		anyContent("Folders", 0, 1),
+		optional("Activities",
+			repeated("Activity", 0,
+				required("Id").text(dateTime),
+				repeated("Lap", 1,
+					required("TotalTimeSeconds").text(positiveNumber),
+					required("DistanceMeters").text(positiveNumber),
+					optional("MaximumSpeed").text(positiveNumber),
+					required("Calories").text(unsignedShort),
+					tcxHeartRate("AverageHeartRateBpm"),
+					tcxHeartRate("MaximumHeartRateBpm"),
+					required("Intensity").text(enumeration("Active", "Resting")),
+					optional("Cadence").text(cadence),
+					required("TriggerMethod").text(enumeration("Manual", "Distance", "Location", "Time", "HeartRate")),
+					repeated("Track", 0,
+						repeated("Trackpoint", 1,
+							required("Time").text(dateTime),
+							optional("Position",
+								required("LatitudeDegrees").text(decimal(-90, 90)),
+								required("LongitudeDegrees").text(decimal(-180, 180)),
+							),
+							optional("AltitudeMeters").text(anyDecimal),
+							optional("DistanceMeters").text(positiveNumber),
+							tcxHeartRate("HeartRateBpm"),
+							optional("Cadence").text(cadence),
+							optional("SensorState").text(enumeration("Present", "Absent")),
+							tcxTrackpointExtensions,
+						),
+					),
+					optional("Notes").text(anyString),
+					tcxLapExtensions,
+				).attributes(
+					attribute{name: "StartTime", required: true, value: dateTime},
+				),
+				optional("Notes").text(anyString),
+				anyContent("Training", 0, 1),
+				anyContent("Creator", 0, 1),
+				anyContent("Extensions", 0, 1),
+			).attributes(
+				attribute{name: "Sport", required: true, value: enumeration(SportRunning, SportBiking, SportOther)},
+			),
+			anyContent("MultiSportSession", 0, unbounded),
+		),
+		anyContent("Workouts", 0, 1),
+		anyContent("Courses", 0, 1),
+		optional("Author",
+			required("Name").text(anyString),
+			required("Build",
+				required("Version",
+					required("VersionMajor").text(unsignedShort),
+					required("VersionMinor").text(unsignedShort),
+					optional("BuildMajor").text(unsignedShort),
+					optional("BuildMinor").text(unsignedShort),
+				),
+				optional("Type").text(enumeration("Internal", "Alpha", "Beta", "Release")),
+				optional("Time").text(anyString),
+				optional("Builder").text(anyString),
+			),
+			required("LangID").text(anyString),
+			required("PartNumber").text(anyString),
+		),
+		anyContent("Extensions", 0, 1),
+	).in(tcxNamespace)
+)
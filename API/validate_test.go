@@ -0,0 +1,140 @@
+package API
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGpxPowerExtension(t *testing.T) {
+	activity := fitTestActivity()
+	if err := ValidateActivity(FormatGpx, activity); err != nil {
+		t.Fatalf("Expected a valid GPX document, got %v", err)
+	}
+
+	content, err := MarshalGpx(BuildGpx(activity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("<pwr:PowerInWatts>250</pwr:PowerInWatts>")) {
+		t.Errorf("Expected the power in the PowerExtension namespace, got %s", content)
+	}
+
+	activities, err := ParseGpx(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	powers := activities[0].Samples(StreamPower)
+	if len(powers) != 5 {
+		t.Fatalf("Expected 5 power samples, got %d", len(powers))
+	}
+	for i, sample := range powers {
+		if sample.Value != float64(250+i) {
+			t.Errorf("Power %d: expected %d, got %v", i, 250+i, sample.Value)
+		}
+	}
+}
+
+func TestValidateGpxRejectsPowerInGpxNamespace(t *testing.T) {
+	document := `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+		<trk><trkseg><trkpt lat="48.8566" lon="2.3522">
+			<time>2026-10-01T07:30:00Z</time>
+			<extensions><power>250</power></extensions>
+		</trkpt></trkseg></trk>
+	</gpx>`
+
+	err := ValidateDocument(FormatGpx, strings.NewReader(document))
+	if err == nil || !strings.Contains(err.Error(), "extensions[0]/power[0]: extension element without namespace") {
+		t.Errorf("Expected the power element to be rejected, got %v", err)
+	}
+}
+
+func TestTcxWithoutDistance(t *testing.T) {
+	activity := fitTestActivity()
+	activity.Summaries = nil
+	for i, stream := range activity.Streams {
+		if stream.Type == StreamDistance {
+			activity.Streams = append(activity.Streams[:i], activity.Streams[i+1:]...)
+			break
+		}
+	}
+
+	tcx := BuildTcx(activity)
+	for _, lap := range tcx.Activities.Activities[0].Laps {
+		for _, point := range lap.Track.Trackpoint {
+			if point.DistanceMeters != nil {
+				t.Fatalf("Expected trackpoints without distance, got %v", *point.DistanceMeters)
+			}
+		}
+	}
+	if err := ValidateTcx(tcx); err != nil {
+		t.Errorf("Expected a valid TCX document, got %v", err)
+	}
+}
+
+func TestValidateTcxRejectsZeroDistances(t *testing.T) {
+	tcx := BuildTcx(fitTestActivity())
+	zero := float32(0)
+	for _, lap := range tcx.Activities.Activities[0].Laps {
+		for i := range lap.Track.Trackpoint {
+			lap.Track.Trackpoint[i].DistanceMeters = &zero
+		}
+	}
+
+	err := ValidateTcx(tcx)
+	if err == nil || !strings.Contains(err.Error(), "every trackpoint distance is zero") {
+		t.Errorf("Expected zero distances to be reported, got %v", err)
+	}
+}
+
+func TestValidateFitReaderChecksSize(t *testing.T) {
+	content, err := MarshalFit(fitTestActivity())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFitReader(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Expected a valid file, got %v", err)
+	}
+
+	for name, file := range map[string][]byte{
+		"truncated":   content[:len(content)-1],
+		"extra bytes": append(append([]byte{}, content...), 0),
+	} {
+		err := ValidateFitReader(bytes.NewReader(file))
+		if err == nil || !strings.Contains(err.Error(), "does not match the file size") {
+			t.Errorf("%v: expected a size error, got %v", name, err)
+		}
+	}
+	if err := ValidateFitReader(strings.NewReader("<gpx/>")); err == nil || !strings.Contains(err.Error(), "not a FIT file") {
+		t.Errorf("Expected an invalid header, got %v", err)
+	}
+}
+
+func TestTcxPosition(t *testing.T) {
+	activity := fitTestActivity()
+	if err := ValidateActivity(FormatTcx, activity); err != nil {
+		t.Fatalf("Expected a valid TCX document, got %v", err)
+	}
+
+	content, err := MarshalTcx(BuildTcx(activity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activities, err := ParseTcx(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trackpoints are written at the time of the speed samples
+	times := Timeline(activity, StreamSpeed)
+	for _, stream := range []StreamType{StreamLatitude, StreamLongitude, StreamElevation} {
+		expected := ResampleStream(activity, stream, times)
+		actual := ResampleStream(activities[0], stream, times)
+		for i := range times {
+			if actual[i] == nil || math.Abs(*actual[i]-*expected[i]) > 1e-5 {
+				t.Errorf("%v at %v: expected %v, got %v", stream, times[i].Sub(activity.StartTime), *expected[i], show(actual[i]))
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"runsync/API"
+)
+
+func runConvert(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	input := flags.String("input", API.DefaultOutputDirectory, "Directory the activities were downloaded to, when no file is given")
+	output := flags.String("output", API.DefaultOutputDirectory, "Directory the converted files are written to")
+	format := flags.String("format", "auto", "Output format: auto (GPX when the activity has a GPS track, TCX otherwise), gpx, tcx, fit, geojson, kml, csv or jsonl")
+	nameTemplate := flags.String("name", os.Getenv("RUNSYNC_NAME_TEMPLATE"), "Template naming the activities, see README for the available fields")
+	laps := flags.String("laps", "km", "Split of the activities without recorded laps: km, mile or none")
+	concurrency := addConcurrencyFlag(flags)
+	selection := addSelectionFlags(flags)
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: runsync convert [flags] [file.json|file.gpx|file.tcx|file.fit...]\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	options, err := selection.listOptions(nil, "")
+	if err != nil {
+		return err
+	}
+
+	namer, err := API.NewNamer(*nameTemplate)
+	if err != nil {
+		return err
+	}
+
+	lapDistance, err := API.ParseLapDistance(*laps)
+	if err != nil {
+		return err
+	}
+
+	// The files converted by a previous run are not read back as activities
+	extensions := []string{API.FormatJson, API.FormatGpx, API.FormatTcx, API.FormatFit}
+	if sameDirectory(*input, *output) {
+		extensions = []string{API.FormatJson}
+	}
+	paths, err := findFiles(flags.Args(), *input, extensions...)
+	if err != nil {
+		return err
+	}
+
+	read := make([][]*API.Activity, len(paths))
+	errs := API.RunTasks(ctx, *concurrency, len(paths), func(ctx context.Context, i int) error {
+		var err error
+		if read[i], err = API.ReadActivitiesFromFile(paths[i]); err != nil {
+			log.WithError(err).Errorf("Fail to read activity file [%v]", paths[i])
+		}
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var activities []*API.Activity
+	failures := countErrors(errs)
+	for _, fileActivities := range read {
+		activities = append(activities, fileActivities...)
+	}
+
+	var selected []*API.Activity
+	for _, activity := range activities {
+		if selection.ids.Matches(activity.ID) && options.Includes(activity.StartTime) {
+			selected = append(selected, activity)
+		}
+	}
+
+	// Activities written as tables are also summarized in a table of their own
+	tables := make([]bool, len(selected))
+	errs = API.RunTasks(ctx, *concurrency, len(selected), func(ctx context.Context, i int) error {
+		activity := selected[i]
+		if err := namer.Apply(activity); err != nil {
+			log.WithError(err).Errorf("Fail to name activity [%v]", activity.ID)
+			return err
+		}
+		API.ApplyLaps(activity, lapDistance)
+
+		target := *format
+		if target == "auto" {
+			target = API.PreferredFormat(activity)
+		}
+
+		converted, err := API.WriteActivityToFile(*output, target, activity)
+		if err != nil {
+			log.WithError(err).Errorf("Fail to convert activity [%v]", activity.ID)
+			return err
+		}
+		log.Infof("Activity [%v] converted to %v", activity.ID, converted)
+
+		tables[i] = target == API.FormatCsv || target == API.FormatJsonl
+		return nil
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	failures += countErrors(errs)
+
+	var tabulated []*API.Activity
+	for i, activity := range selected {
+		if tables[i] {
+			tabulated = append(tabulated, activity)
+		}
+	}
+
+	if len(tabulated) > 0 {
+		summary, err := API.WriteSummariesToFile(*output, *format, tabulated)
+		if err != nil {
+			return err
+		}
+		log.Infof("%v activities summarized in %v", len(tabulated), summary)
+	}
+
+	if failures > 0 {
+		return errors.Errorf("%v activities or files failed to convert", failures)
+	}
+	return nil
+}
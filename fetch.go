@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"runsync/API"
+	"sync"
+	"time"
+)
+
+func runFetch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	sourceName := flags.String("source", "nike", "Platform to retrieve activities from")
+	statePath := flags.String("state", API.DefaultStatePath, "File storing the state of the synchronization")
+	output := flags.String("output", API.DefaultOutputDirectory, "Directory the activities are downloaded to")
+	concurrency := addConcurrencyFlag(flags)
+	selection := addSelectionFlags(flags)
+	flags.Parse(args)
+
+	source, err := API.NewSource(*sourceName)
+	if err != nil {
+		return errors.WithMessage(err, "Fail to initialize source")
+	}
+
+	state, err := API.LoadState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	// Unlike sync, fetch downloads the whole selection unless a since date is given
+	refs, err := listActivities(ctx, source, *sourceName, nil, selection)
+	if err != nil {
+		return errors.WithMessagef(err, "Fail to load activities from [%v]", *sourceName)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mutex sync.Mutex
+	errs := API.RunTasks(ctx, *concurrency, len(refs), func(ctx context.Context, i int) error {
+		ref := refs[i]
+		activity, err := source.FetchActivity(ctx, ref.ID)
+		if err != nil {
+			if stopsBatch(err) {
+				cancel()
+			}
+			log.WithError(err).Errorf("Fail to fetch activity [%v]", ref.ID)
+			return err
+		}
+
+		path, err := API.WriteActivityToFile(*output, API.FormatJson, activity)
+		if err != nil {
+			log.WithError(err).Errorf("Fail to write activity [%v]", ref.ID)
+			return err
+		}
+		log.Infof("Activity [%v] downloaded to %v", ref.ID, path)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		// The update time is left to sync and upload, which compare it once the activity is pushed
+		entry := state.Entry(*sourceName, ref.ID)
+		entry.StartTime = activity.StartTime
+		entry.FetchedAt = time.Now()
+		return nil
+	})
+
+	failures := countErrors(errs)
+
+	if err := state.Save(); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return errors.Errorf("%v of %v activities failed to download", failures, len(refs))
+	}
+	return nil
+}
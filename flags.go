@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"runsync/API"
+	"sort"
+	"strings"
+	"time"
+)
+
+// idList collects activity identifiers, given either repeatedly or comma separated
+type idList []string
+
+func (l *idList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *idList) Set(value string) error {
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			*l = append(*l, id)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the identifier is selected, every identifier is when the list is empty
+func (l idList) Matches(id string) bool {
+	return len(l) == 0 || API.Contains(l, id)
+}
+
+// selection holds the flags restricting the activities a command works on
+type selection struct {
+	since string
+	until string
+	ids   idList
+}
+
+func addSelectionFlags(flags *flag.FlagSet) *selection {
+	s := &selection{}
+	flags.StringVar(&s.since, "since", "", "Only process activities started after this date (YYYY-MM-DD or RFC3339)")
+	flags.StringVar(&s.until, "until", "", "Only process activities started before this date (YYYY-MM-DD or RFC3339)")
+	flags.Var(&s.ids, "id", "Only process the activity with this identifier, can be repeated")
+	return s
+}
+
+// addConcurrencyFlag registers the flag setting the number of activities processed at once
+func addConcurrencyFlag(flags *flag.FlagSet) *int {
+	return flags.Int("concurrency", API.DefaultConcurrency, "Number of activities processed at once")
+}
+
+// uploadFormats returns the formats of the files pushed to a sink for a format flag
+func uploadFormats(format string) ([]string, error) {
+	switch format {
+	case "auto":
+		return []string{API.FormatGpx, API.FormatTcx, API.FormatFit}, nil
+	case API.FormatGpx, API.FormatTcx, API.FormatFit:
+		return []string{format}, nil
+	default:
+		return nil, errors.Errorf("Unsupported upload format [%v], expecting auto, gpx, tcx or fit", format)
+	}
+}
+
+// stopsBatch reports whether every remaining activity would fail the same way after err
+func stopsBatch(err error) bool {
+	return errors.Is(err, API.ErrUnauthorized) || errors.Is(err, API.ErrRateLimited)
+}
+
+// countErrors returns the number of failed tasks
+func countErrors(errs []error) int {
+	count := 0
+	for _, err := range errs {
+		if err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// listOptions builds the listing options, resuming from the source cursor when no since date is given
+func (s *selection) listOptions(state *API.State, sourceName string) (API.ListOptions, error) {
+	var err error
+	options := API.ListOptions{}
+	if len(s.since) > 0 {
+		if options.Since, err = parseDate(s.since); err != nil {
+			return options, err
+		}
+	} else if state != nil {
+		cursor := state.Cursor(sourceName)
+		options.Since = cursor.AfterTime
+		options.AfterID = cursor.AfterID
+	}
+	if len(s.until) > 0 {
+		if options.Until, err = parseDate(s.until); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// listActivities returns the selected activities of a source, oldest first
+func listActivities(ctx context.Context, source API.Source, sourceName string, state *API.State, s *selection) ([]API.ActivityRef, error) {
+	if len(s.ids) > 0 {
+		refs := make([]API.ActivityRef, 0, len(s.ids))
+		for _, id := range s.ids {
+			refs = append(refs, API.ActivityRef{ID: id})
+		}
+		return refs, nil
+	}
+
+	options, err := s.listOptions(state, sourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := source.ListActivities(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].StartTime.Before(refs[j].StartTime)
+	})
+	return refs, nil
+}
+
+// parseDate accepts either a day or a full RFC3339 timestamp
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// findFiles returns the files given as arguments, or the activity and archive files of
+// directory having one of the extensions, a single one per activity
+func findFiles(args []string, directory string, extensions ...string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+
+	var paths []string
+	for _, extension := range extensions {
+		matches, err := filepath.Glob(filepath.Join(directory, "*."+extension))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if len(API.ActivityIDFromPath(match)) > 0 || API.IsArchivePath(match) {
+				paths = append(paths, match)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return API.DistinctActivityFiles(paths), nil
+}
+
+// sameDirectory reports whether both paths designate the same directory
+func sameDirectory(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
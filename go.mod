@@ -3,7 +3,7 @@ module runsync
 go 1.15
 
 require (
-	github.com/joho/godotenv v1.3.0 // indirect
+	github.com/joho/godotenv v1.3.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.7.0
 	moul.io/http2curl v1.0.0
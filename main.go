@@ -1,13 +1,41 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
-	"runsync/API"
-	"runsync/API/nike"
-	"runsync/API/strava"
+	"os"
+	"os/signal"
+	_ "runsync/API/file"
+	_ "runsync/API/nike"
+	_ "runsync/API/strava"
 )
 
+const usage = `Usage: runsync <command> [flags]
+
+Commands:
+  sync     Fetch activities from a source and push them to a sink
+  fetch    Download activities from a source to disk
+  convert  Convert downloaded activities to GPX, TCX or FIT files
+  upload   Push existing activity files to a sink
+  validate Check activity files against the GPX, TCX and FIT formats
+  status   Show the synchronization state
+
+Run 'runsync <command> -h' to list the flags of a command.
+`
+
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"sync":     runSync,
+	"fetch":    runFetch,
+	"convert":  runConvert,
+	"upload":   runUpload,
+	"validate": runValidate,
+	"status":   runStatus,
+}
+
 func main() {
 	// Initialize Logger
 	log.SetFormatter(&log.TextFormatter{
@@ -16,42 +44,42 @@ func main() {
 		FullTimestamp: true,
 	})
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Error("Error loading .env file")
-		log.Exit(1)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	runs, err := nike.GetRunsFromNRC()
-	if err != nil {
-		log.Error("Error will loading data from Nike Run Club")
-		log.Exit(1)
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
 	}
 
-	log.WithFields(
-		log.Fields{
-			"length": len(runs),
-		},
-	).Info("Runs retrieved from Nike Run Club")
-
-	paths := []string{}
-
-	for _, run := range runs {
-		if API.Contains(run.MetricTypes, "longitude") && API.Contains(run.MetricTypes, "longitude") {
-			gpx := nike.BuildGpxFromActivity(run)
-			if nil != gpx {
-				paths = append(paths, API.WriteGpxToFile(run.ID, gpx))
-			}
-		} else {
-			tcx := nike.BuildTcxFromActivity(run)
-			if nil != tcx {
-				paths = append(paths, API.WriteTcxToFile(run.ID, tcx))
-			}
-		}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command [%v]\n\n%v", name, usage)
+		os.Exit(2)
 	}
 
-	for _, path := range paths {
-		strava.ImportDataFromFiles(path)
+	// Credentials can also be provided through the environment
+	if err := godotenv.Load(); err != nil {
+		log.Debug("No .env file loaded")
 	}
 
+	// Interrupting stops starting new activities, the ones in progress are cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		log.Warn("Interrupted, stopping")
+		cancel()
+		signal.Stop(interrupts)
+	}()
+
+	if err := run(ctx, os.Args[2:]); err != nil {
+		log.WithError(err).Errorf("Command [%v] failed", name)
+		log.Exit(1)
+	}
 }
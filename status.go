@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runsync/API"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+func runStatus(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	statePath := flags.String("state", API.DefaultStatePath, "File storing the state of the synchronization")
+	sourceName := flags.String("source", "", "Only show the activities of this source")
+	selection := addSelectionFlags(flags)
+	flags.Parse(args)
+
+	options, err := selection.listOptions(nil, "")
+	if err != nil {
+		return err
+	}
+
+	state, err := API.LoadState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SOURCE\tCURSOR\tAFTER ID")
+	sources := make([]string, 0, len(state.Cursors))
+	for source := range state.Cursors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if len(*sourceName) > 0 && source != *sourceName {
+			continue
+		}
+		cursor := state.Cursors[source]
+		fmt.Fprintf(writer, "%v\t%v\t%v\n", source, formatTime(cursor.AfterTime), cursor.AfterID)
+	}
+	fmt.Fprintln(writer)
+
+	fmt.Fprintln(writer, "SOURCE\tID\tSTART\tFETCHED\tUPLOADED\tUPLOAD ID\tACTIVITY ID\tFILE")
+	for _, entry := range state.Entries() {
+		if len(*sourceName) > 0 && entry.Source != *sourceName {
+			continue
+		}
+		// Entries recorded before their start time was stored are left out of a date range
+		if !selection.ids.Matches(entry.ID) || !options.Includes(entry.StartTime) {
+			continue
+		}
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			entry.Source,
+			entry.ID,
+			formatTime(entry.StartTime),
+			formatTime(entry.FetchedAt),
+			formatTime(entry.UploadedAt),
+			entry.UploadID,
+			entry.ActivityID,
+			entry.Path,
+		)
+	}
+	return writer.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
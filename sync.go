@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"runsync/API"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// syncer runs the whole pipeline for an activity: fetch, conversion and push
+type syncer struct {
+	source     API.Source
+	sourceName string
+	sink       API.Sink
+	sinkName   string
+	state      *API.State
+	output     string
+	namer      *API.Namer
+	// lapDistance splits activities without recorded laps, 0 keeps a single lap
+	lapDistance float64
+	// format of the files pushed, auto chooses it per activity
+	format string
+
+	// dryRun reports what would be uploaded instead of writing files and pushing them
+	dryRun bool
+
+	// mutex guards the state and the previews, activities are synchronized concurrently
+	mutex sync.Mutex
+	// previews holds the dry run report line of each activity, printed in order
+	previews map[string]string
+}
+
+func runSync(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	sourceName := flags.String("source", "nike", "Platform to retrieve activities from")
+	sinkName := flags.String("sink", "strava", "Platform to push activities to")
+	statePath := flags.String("state", API.DefaultStatePath, "File storing the state of the synchronization")
+	output := flags.String("output", API.DefaultOutputDirectory, "Directory the activity files are written to")
+	nameTemplate := flags.String("name", os.Getenv("RUNSYNC_NAME_TEMPLATE"), "Template naming the activities, see README for the available fields")
+	laps := flags.String("laps", "km", "Split of the activities without recorded laps: km, mile or none")
+	format := flags.String("format", "auto", "Format of the files pushed: auto (GPX when the activity has a GPS track, TCX otherwise), gpx, tcx or fit")
+	dryRun := flags.Bool("dry-run", false, "Show what would be uploaded without writing files, pushing them nor updating the state")
+	concurrency := addConcurrencyFlag(flags)
+	selection := addSelectionFlags(flags)
+	flags.Parse(args)
+
+	source, err := API.NewSource(*sourceName)
+	if err != nil {
+		return errors.WithMessage(err, "Fail to initialize source")
+	}
+
+	// A dry run writes nothing, the documents retrieved are not archived either
+	if archiving, ok := source.(API.ArchivingSource); ok && *dryRun {
+		archiving.DisableArchive()
+	}
+
+	var sink API.Sink
+	if !*dryRun {
+		sink, err = API.NewSink(*sinkName)
+		if err != nil {
+			return errors.WithMessage(err, "Fail to initialize sink")
+		}
+	}
+
+	state, err := API.LoadState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	namer, err := API.NewNamer(*nameTemplate)
+	if err != nil {
+		return err
+	}
+
+	lapDistance, err := API.ParseLapDistance(*laps)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uploadFormats(*format); err != nil {
+		return err
+	}
+
+	refs, err := listActivities(ctx, source, *sourceName, state, selection)
+	if err != nil {
+		return errors.WithMessagef(err, "Fail to load activities from [%v]", *sourceName)
+	}
+
+	log.WithFields(
+		log.Fields{
+			"length": len(refs),
+		},
+	).Infof("Activities retrieved from [%v]", *sourceName)
+
+	s := &syncer{
+		source:      source,
+		sourceName:  *sourceName,
+		sink:        sink,
+		sinkName:    *sinkName,
+		state:       state,
+		output:      *output,
+		namer:       namer,
+		lapDistance: lapDistance,
+		format:      *format,
+		dryRun:      *dryRun,
+		previews:    map[string]string{},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := API.RunTasks(ctx, *concurrency, len(refs), func(ctx context.Context, i int) error {
+		err := s.sync(ctx, refs[i])
+		if stopsBatch(err) {
+			cancel()
+		}
+		return err
+	})
+
+	if *dryRun {
+		report := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(report, "ID\tSTART\tNAME\tDISTANCE\tDURATION\tFORMAT\tACTION")
+		for _, ref := range refs {
+			if line, ok := s.previews[ref.ID]; ok {
+				fmt.Fprintln(report, line)
+			}
+		}
+		report.Flush()
+	}
+
+	// The cursor only moves forward while every activity before it is synchronized
+	gap := false
+	failures := 0
+	for i, ref := range refs {
+		if err := errs[i]; err != nil {
+			if stopsBatch(err) || errors.Is(err, context.Canceled) {
+				if !*dryRun {
+					state.Save()
+				}
+				return errors.WithMessagef(batchError(errs, err), "Synchronization stopped at activity [%v]", ref.ID)
+			}
+			log.WithError(err).Errorf("Fail to synchronize activity [%v]", ref.ID)
+			gap = true
+			failures++
+		} else if !gap {
+			state.Advance(*sourceName, ref)
+		}
+	}
+
+	if !*dryRun {
+		if err := state.Save(); err != nil {
+			return err
+		}
+	}
+
+	if failures > 0 {
+		return errors.Errorf("%v of %v activities failed to synchronize", failures, len(refs))
+	}
+	return nil
+}
+
+func (s *syncer) sync(ctx context.Context, ref API.ActivityRef) error {
+	s.mutex.Lock()
+	needsFetch := s.state.NeedsFetch(s.sourceName, ref)
+	s.mutex.Unlock()
+	if !needsFetch {
+		log.Debugf("Activity [%v] already synchronized", ref.ID)
+		return nil
+	}
+
+	activity, err := s.source.FetchActivity(ctx, ref.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.namer.Apply(activity); err != nil {
+		return err
+	}
+	API.ApplyLaps(activity, s.lapDistance)
+
+	if s.dryRun {
+		return s.preview(ref, activity)
+	}
+
+	path, err := API.WriteActivityToFile(s.output, s.formatOf(activity), activity)
+	if err != nil {
+		return err
+	}
+
+	hash, err := API.FileHash(path)
+	if err != nil {
+		return errors.WithMessagef(err, "Fail to hash file [%v]", path)
+	}
+
+	// The source update time is only recorded once the file is on the sink, a failed
+	// push is then retried on the next run
+	s.mutex.Lock()
+	upload := s.state.NeedsUpload(s.sourceName, ref.ID, hash)
+	entry := s.state.Entry(s.sourceName, ref.ID)
+	entry.StartTime = activity.StartTime
+	entry.FetchedAt = time.Now()
+	entry.Path = path
+	if !upload {
+		entry.UpdatedAt = ref.UpdatedAt
+	}
+	s.mutex.Unlock()
+
+	if !upload {
+		log.Infof("Activity [%v] unchanged since its last upload", ref.ID)
+		return nil
+	}
+
+	result, err := s.sink.Push(ctx, path, activity)
+	if err != nil {
+		return errors.WithMessagef(err, "[%v] Push failed", s.sinkName)
+	}
+
+	// The state is saved after each upload so that an interrupted run does not upload again
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry.UpdatedAt = ref.UpdatedAt
+	entry.FileHash = hash
+	entry.UploadID = result.UploadID
+	entry.ActivityID = result.ActivityID
+	entry.UploadedAt = time.Now()
+	return s.state.Save()
+}
+
+// formatOf returns the format of the file pushed for the activity
+func (s *syncer) formatOf(activity *API.Activity) string {
+	if s.format == "auto" {
+		return API.PreferredFormat(activity)
+	}
+	return s.format
+}
+
+// preview builds and validates the document of an activity and records what sync would do with it
+func (s *syncer) preview(ref API.ActivityRef, activity *API.Activity) error {
+	format := s.formatOf(activity)
+	content, err := API.MarshalActivity(format, activity)
+	if err != nil {
+		return err
+	}
+	validation := API.ValidateActivity(format, activity)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	action := "upload"
+	if err := validation; err != nil {
+		action = "invalid"
+		log.WithError(err).Warnf("Activity [%v] would be rejected", activity.ID)
+	} else if !s.state.NeedsUpload(s.sourceName, ref.ID, API.ContentHash(content)) {
+		action = "unchanged"
+	}
+
+	s.previews[ref.ID] = fmt.Sprintf("%v\t%v\t%v\t%.2f km\t%v\t%v\t%v",
+		activity.ID,
+		activity.StartTime.Local().Format("2006-01-02 15:04"),
+		activity.Name,
+		activity.Distance()/1000,
+		activity.Duration.Round(time.Second),
+		format,
+		action,
+	)
+	return nil
+}
+
+// batchError returns the error which stopped a batch, err when no task failed for a
+// reason stopping the batch
+func batchError(errs []error, err error) error {
+	for _, e := range errs {
+		if stopsBatch(e) {
+			return e
+		}
+	}
+	return err
+}
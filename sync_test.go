@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runsync/API"
+	"testing"
+	"time"
+)
+
+// testSource serves a single activity whose heart rate and update time can be changed
+type testSource struct {
+	updatedAt time.Time
+	heartRate float64
+}
+
+func (s *testSource) ListActivities(ctx context.Context, options API.ListOptions) ([]API.ActivityRef, error) {
+	return []API.ActivityRef{{ID: "1", StartTime: testStart, UpdatedAt: s.updatedAt}}, nil
+}
+
+func (s *testSource) FetchActivity(ctx context.Context, id string) (*API.Activity, error) {
+	return &API.Activity{
+		ID:        id,
+		Sport:     API.SportRunning,
+		StartTime: testStart,
+		Duration:  10 * time.Second,
+		Streams: []API.Stream{{Type: API.StreamHeartRate, Samples: []API.Sample{
+			{Start: testStart, End: testStart, Value: s.heartRate},
+			{Start: testStart.Add(10 * time.Second), End: testStart.Add(10 * time.Second), Value: s.heartRate},
+		}}},
+	}, nil
+}
+
+// testSink records the pushes and fails them while err is set
+type testSink struct {
+	pushes int
+	err    error
+}
+
+func (s *testSink) Push(ctx context.Context, path string, activity *API.Activity) (*API.PushResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.pushes++
+	return &API.PushResult{ActivityID: "42"}, nil
+}
+
+var (
+	testStart      = time.Date(2026, 10, 1, 7, 30, 0, 0, time.UTC)
+	testSyncSource = &testSource{}
+	testSyncSink   = &testSink{}
+)
+
+func init() {
+	API.RegisterSource("test", func() (API.Source, error) { return testSyncSource, nil })
+	API.RegisterSink("test", func() (API.Sink, error) { return testSyncSink, nil })
+}
+
+func TestSyncRetriesFailedUpload(t *testing.T) {
+	directory, err := ioutil.TempDir("", "runsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	args := []string{
+		"-source", "test",
+		"-sink", "test",
+		"-state", filepath.Join(directory, "state.json"),
+		"-output", directory,
+		"-laps", "none",
+	}
+
+	testSyncSource.updatedAt = testStart
+	testSyncSource.heartRate = 140
+	if err := runSync(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+
+	// The activity is modified on the source and its new upload fails
+	testSyncSource.updatedAt = testStart.Add(time.Hour)
+	testSyncSource.heartRate = 150
+	testSyncSink.err = errors.New("unavailable")
+	if err := runSync(context.Background(), args); err == nil {
+		t.Fatal("Expected the synchronization to fail")
+	}
+
+	testSyncSink.err = nil
+	if err := runSync(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	if testSyncSink.pushes != 2 {
+		t.Errorf("Expected the modified activity to be uploaded again, got %d uploads", testSyncSink.pushes)
+	}
+
+	// Once uploaded, the activity is no longer synchronized
+	if err := runSync(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	if testSyncSink.pushes != 2 {
+		t.Errorf("Expected no new upload, got %d uploads", testSyncSink.pushes)
+	}
+}
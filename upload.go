@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"runsync/API"
+	"sync"
+	"time"
+)
+
+func runUpload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	sourceName := flags.String("source", "nike", "Platform the files were retrieved from, used to record uploads in the state")
+	sinkName := flags.String("sink", "strava", "Platform to push activities to")
+	statePath := flags.String("state", API.DefaultStatePath, "File storing the state of the synchronization")
+	input := flags.String("input", API.DefaultOutputDirectory, "Directory the activity files are read from, when no file is given")
+	force := flags.Bool("force", false, "Upload files even if they have already been uploaded")
+	format := flags.String("format", "auto", "Format of the files read from the input directory: auto (a single file per activity, FIT first then TCX and GPX), gpx, tcx or fit")
+	concurrency := addConcurrencyFlag(flags)
+	selection := addSelectionFlags(flags)
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: runsync upload [flags] [file...]\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	sink, err := API.NewSink(*sinkName)
+	if err != nil {
+		return errors.WithMessage(err, "Fail to initialize sink")
+	}
+
+	state, err := API.LoadState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	options, err := selection.listOptions(nil, "")
+	if err != nil {
+		return err
+	}
+
+	formats, err := uploadFormats(*format)
+	if err != nil {
+		return err
+	}
+
+	paths, err := findFiles(flags.Args(), *input, formats...)
+	if err != nil {
+		return err
+	}
+
+	// Files whose activity cannot be read have no start time, they are left out of a date range
+	var selected []string
+	var activities []*API.Activity
+	for _, path := range paths {
+		if !selection.ids.Matches(API.ActivityIDFromPath(path)) {
+			continue
+		}
+		activity := readUploadedActivity(path)
+		if !options.Since.IsZero() || !options.Until.IsZero() {
+			if activity == nil || !options.Includes(activity.StartTime) {
+				continue
+			}
+		}
+		selected = append(selected, path)
+		activities = append(activities, activity)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mutex sync.Mutex
+	errs := API.RunTasks(ctx, *concurrency, len(selected), func(ctx context.Context, i int) error {
+		path := selected[i]
+		id := API.ActivityIDFromPath(path)
+
+		hash, err := API.FileHash(path)
+		if err != nil {
+			log.WithError(err).Errorf("Fail to hash file [%v]", path)
+			return err
+		}
+
+		mutex.Lock()
+		needsUpload := len(id) == 0 || *force || state.NeedsUpload(*sourceName, id, hash)
+		mutex.Unlock()
+		if !needsUpload {
+			log.Infof("File [%v] already uploaded", path)
+			return nil
+		}
+
+		result, err := sink.Push(ctx, path, activities[i])
+		if stopsBatch(err) {
+			cancel()
+			return err
+		}
+		if err != nil {
+			log.WithError(err).Errorf("[%v] Push failed", *sinkName)
+			return err
+		}
+		log.Infof("File [%v] pushed as activity [%v]", path, result.ActivityID)
+
+		if len(id) > 0 {
+			mutex.Lock()
+			defer mutex.Unlock()
+			entry := state.Entry(*sourceName, id)
+			if activity := activities[i]; activity != nil {
+				entry.StartTime = activity.StartTime
+			}
+			entry.Path = path
+			entry.FileHash = hash
+			entry.UploadID = result.UploadID
+			entry.ActivityID = result.ActivityID
+			entry.UploadedAt = time.Now()
+			return state.Save()
+		}
+		return nil
+	})
+
+	for i, err := range errs {
+		if stopsBatch(err) || errors.Is(err, context.Canceled) {
+			return errors.WithMessagef(batchError(errs, err), "Upload stopped at file [%v]", selected[i])
+		}
+	}
+	failures := countErrors(errs)
+
+	if failures > 0 {
+		return errors.Errorf("%v of %v files failed to upload", failures, len(selected))
+	}
+	return nil
+}
+
+// readUploadedActivity returns the activity downloaded next to a file by fetch, or the
+// activity read from the file itself, nil when neither can be read
+func readUploadedActivity(path string) *API.Activity {
+	if id := API.ActivityIDFromPath(path); len(id) > 0 {
+		activity, err := API.ReadActivityFromFile(API.ActivityPath(filepath.Dir(path), id, API.FormatJson))
+		if err == nil {
+			return activity
+		}
+	}
+
+	activities, err := API.ReadActivitiesFromFile(path)
+	if err != nil || len(activities) != 1 {
+		return nil
+	}
+	return activities[0]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"runsync/API"
+)
+
+func runValidate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	input := flags.String("input", API.DefaultOutputDirectory, "Directory the activity files are read from, when no file is given")
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: runsync validate [flags] [file...]\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	paths, err := findFiles(flags.Args(), *input, API.FormatGpx, API.FormatTcx, API.FormatFit)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, path := range paths {
+		err := API.ValidateFile(path)
+		if err == nil {
+			fmt.Printf("%v: OK\n", path)
+			continue
+		}
+
+		failures++
+		var validationErrors API.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			for _, e := range validationErrors {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", path, e)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+		}
+	}
+
+	if failures > 0 {
+		return errors.Errorf("%v of %v files are invalid", failures, len(paths))
+	}
+	return nil
+}